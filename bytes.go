@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/streamingfast/logging"
 	tracing "github.com/streamingfast/sf-tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -121,11 +124,43 @@ func (b *meter) AddBytesRead(n int) {
 func (b *meter) AddBytesWrittenCtx(ctx context.Context, n int) {
 	logDataFromCtx(ctx, n, modeWrite)
 	b.AddBytesWritten(n)
+	recordOnSpan(ctx, modeWrite, n, b.BytesWritten())
 }
 
 func (b *meter) AddBytesReadCtx(ctx context.Context, n int) {
 	logDataFromCtx(ctx, n, modeRead)
 	b.AddBytesRead(n)
+	recordOnSpan(ctx, modeRead, n, b.BytesRead())
+}
+
+var spanRecording atomic.Bool
+
+// SetSpanRecording controls whether AddBytesReadCtx and AddBytesWrittenCtx
+// record on the active span of their context, it's disabled by default. When
+// enabled, every call adds a `metering.bytes_read` or `metering.bytes_written`
+// event holding the byte count and updates the `metering.bytes_read_total` or
+// `metering.bytes_written_total` attribute with the meter's total.
+func SetSpanRecording(enabled bool) {
+	spanRecording.Store(enabled)
+}
+
+func recordOnSpan(ctx context.Context, mode mode, nBytes int, total uint64) {
+	if !spanRecording.Load() {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	name := "metering.bytes_read"
+	if mode == modeWrite {
+		name = "metering.bytes_written"
+	}
+
+	span.AddEvent(name, trace.WithAttributes(attribute.Int("metering.bytes", nBytes)))
+	span.SetAttributes(attribute.Int64(name+"_total", int64(total)))
 }
 
 func (b *meter) BytesWritten() uint64 {
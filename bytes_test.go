@@ -2,7 +2,14 @@ package dmetering
 
 import (
 	"context"
+	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestMeter_BytesWrittenDelta(t *testing.T) {
@@ -96,3 +103,38 @@ func TestWithExistingBytesMeter_nil(t *testing.T) {
 		t.Error("expected a noop meter")
 	}
 }
+
+func TestMeter_SpanRecording(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	meter := NewBytesMeter()
+	ctx, span := tracer.Start(context.Background(), "request")
+
+	meter.AddBytesReadCtx(ctx, 10)
+
+	SetSpanRecording(true)
+	defer SetSpanRecording(false)
+
+	meter.AddBytesReadCtx(ctx, 5)
+	meter.AddBytesWrittenCtx(ctx, 3)
+	meter.AddBytesReadCtx(context.Background(), 100)
+	span.End()
+
+	require.Len(t, recorder.Ended(), 1)
+	ended := recorder.Ended()[0]
+
+	var events []string
+	for _, event := range ended.Events() {
+		events = append(events, fmt.Sprintf("%s %s=%s", event.Name, event.Attributes[0].Key, event.Attributes[0].Value.Emit()))
+	}
+	assert.Equal(t, []string{
+		"metering.bytes_read metering.bytes=5",
+		"metering.bytes_written metering.bytes=3",
+	}, events)
+
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attribute.Int64("metering.bytes_read_total", 15),
+		attribute.Int64("metering.bytes_written_total", 3),
+	}, ended.Attributes())
+}
@@ -0,0 +1,59 @@
+// Package clock abstracts the passing of time so that code batching or
+// flushing on a schedule can be tested deterministically with a Fake clock.
+package clock
+
+import (
+	"time"
+)
+
+// Clock is the subset of the time package used by emitters.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker mirrors *time.Ticker, C being a method so fakes can implement it.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer mirrors the *time.Timer returned by time.AfterFunc.
+type Timer interface {
+	Stop() bool
+}
+
+// Real is the Clock backed by the time package.
+var Real Clock = realClock{}
+
+// OrReal returns c or Real when c is nil, so that a Clock can be an optional
+// configuration field.
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,165 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance is called. Tickers and
+// timers created from it fire from within Advance once their deadline is
+// reached, which makes schedules observable without waiting on the wall clock.
+type Fake struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// NewFake returns a Fake clock starting at now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.lock)
+	return f
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	// period is zero for timers, which fire once
+	period  time.Duration
+	ch      chan time.Time
+	f       func()
+	stopped bool
+}
+
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	// Like time.Ticker, the channel holds a single tick and slow receivers miss the others
+	return &fakeTicker{clock: f, waiter: f.add(&fakeWaiter{period: d, ch: make(chan time.Time, 1)}, d)}
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return &fakeTimer{clock: f, waiter: f.add(&fakeWaiter{f: fn}, d)}
+}
+
+func (f *Fake) add(w *fakeWaiter, d time.Duration) *fakeWaiter {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	w.deadline = f.now.Add(d)
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+	return w
+}
+
+// Advance moves the clock forward by d, firing every ticker and timer whose
+// deadline is reached along the way, in deadline order. Timer functions are
+// called synchronously, before Advance returns.
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	end := f.now.Add(d)
+
+	for {
+		next := f.nextDue(end)
+		if next == nil {
+			break
+		}
+
+		f.now = next.deadline
+		if next.period > 0 {
+			next.deadline = next.deadline.Add(next.period)
+			select {
+			case next.ch <- f.now:
+			default:
+			}
+			continue
+		}
+
+		next.stopped = true
+		f.removeLocked(next)
+		f.lock.Unlock()
+		next.f()
+		f.lock.Lock()
+	}
+
+	f.now = end
+	f.lock.Unlock()
+}
+
+func (f *Fake) nextDue(end time.Time) (next *fakeWaiter) {
+	for _, w := range f.waiters {
+		if w.deadline.After(end) {
+			continue
+		}
+		if next == nil || w.deadline.Before(next.deadline) {
+			next = w
+		}
+	}
+	return
+}
+
+// BlockUntil waits until at least n tickers and timers are active on the clock,
+// use it to make sure the code under test scheduled its work before calling
+// Advance.
+func (f *Fake) BlockUntil(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) stop(w *fakeWaiter) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if w.stopped {
+		return false
+	}
+
+	w.stopped = true
+	f.removeLocked(w)
+	return true
+}
+
+func (f *Fake) removeLocked(w *fakeWaiter) {
+	for i, candidate := range f.waiters {
+		if candidate == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			break
+		}
+	}
+	f.cond.Broadcast()
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	waiter *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.waiter.ch
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.stop(t.waiter)
+}
+
+type fakeTimer struct {
+	clock  *Fake
+	waiter *fakeWaiter
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.clock.stop(t.waiter)
+}
@@ -0,0 +1,64 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake_Ticker(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewFake(start)
+	ticker := clock.NewTicker(10 * time.Second)
+
+	clock.Advance(9 * time.Second)
+	assertNoTick(t, ticker)
+
+	clock.Advance(time.Second)
+	assert.Equal(t, start.Add(10*time.Second), <-ticker.C())
+
+	// A slow receiver only gets the first tick of the ones it missed
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, start.Add(20*time.Second), <-ticker.C())
+	assertNoTick(t, ticker)
+	assert.Equal(t, start.Add(40*time.Second), clock.Now())
+
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	assertNoTick(t, ticker)
+}
+
+func TestFake_AfterFunc(t *testing.T) {
+	clock := NewFake(time.Unix(0, 0))
+
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "second") })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "first") })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, []string{"first", "second"}, fired)
+}
+
+func TestFake_BlockUntil(t *testing.T) {
+	clock := NewFake(time.Unix(0, 0))
+
+	go clock.NewTicker(time.Second)
+	go clock.AfterFunc(time.Second, func() {})
+
+	clock.BlockUntil(2)
+}
+
+func assertNoTick(t *testing.T, ticker Ticker) {
+	t.Helper()
+
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("unexpected tick at %s", tick)
+	default:
+	}
+}
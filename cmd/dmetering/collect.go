@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/dmetering/collector"
+	"github.com/streamingfast/dmetering/collector/boltstore"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var collectCmd = &cobra.Command{
+	Use:   "collect",
+	Short: "Run a local plaintext collector printing every event it receives",
+	Long: strings.TrimSpace(`
+Run a local plaintext collector printing every event it receives. Point an
+emitter at it with "grpc://<listen-addr>?network=<network>". The json output
+writes one event per line and can be fed back to the replay command.
+
+Received events are also aggregated in memory, or persisted in a local
+database with --store-path, query them with the usage command.
+`),
+	Args: cobra.NoArgs,
+	RunE: runCollect,
+}
+
+func init() {
+	collectCmd.Flags().String("listen-addr", "localhost:9010", "Address the collector listens on")
+	collectCmd.Flags().String("output", "text", "Output format of received events, one of text or json")
+	collectCmd.Flags().Duration("usage-resolution", 0, "Size of the time buckets received events are aggregated in, the database's or 1m when 0")
+	collectCmd.Flags().String("store-path", "", "Path of a database persisting received events and their aggregates, kept in memory when unset")
+	collectCmd.Flags().Duration("event-retention", 0, "How long the database keeps received events, forever when 0")
+	collectCmd.Flags().Duration("rollup-retention", 0, "How long the database keeps aggregated usage, forever when 0")
+}
+
+func runCollect(cmd *cobra.Command, _ []string) error {
+	listenAddr, _ := cmd.Flags().GetString("listen-addr")
+	output, _ := cmd.Flags().GetString("output")
+	resolution, _ := cmd.Flags().GetDuration("usage-resolution")
+	storePath, _ := cmd.Flags().GetString("store-path")
+
+	var format func(*pbmetering.Event) (string, error)
+	switch output {
+	case "text":
+		format = formatEventText
+	case "json":
+		format = formatEventJSON
+	default:
+		return fmt.Errorf("invalid output value %q: expected one of %q or %q", output, "text", "json")
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %q: %w", listenAddr, err)
+	}
+
+	server := grpc.NewServer()
+	var store usageStore = collector.NewMemoryStore(resolution, nil)
+	if storePath != "" {
+		eventRetention, _ := cmd.Flags().GetDuration("event-retention")
+		rollupRetention, _ := cmd.Flags().GetDuration("rollup-retention")
+
+		boltStore, err := boltstore.Open(storePath, &boltstore.Config{
+			Resolution:         resolution,
+			EventRetention:     eventRetention,
+			RollupRetention:    rollupRetention,
+			CompactionInterval: storeCompactionInterval,
+		}, zlog)
+		if err != nil {
+			return err
+		}
+		defer boltStore.Close()
+		store = boltStore
+	}
+
+	pbmetering.RegisterMeteringServer(server, collector.NewServer(newPrintSink(cmd.OutOrStdout(), format, store), zlog))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		zlog.Info("received termination signal, stopping collector")
+		server.GracefulStop()
+	}()
+
+	zlog.Info("collector listening", zap.String("listen_addr", listener.Addr().String()))
+	return server.Serve(listener)
+}
+
+const storeCompactionInterval = 10 * time.Minute
+
+type usageStore interface {
+	collector.Sink
+	collector.UsageQuerier
+}
+
+// printSink prints received events before handing them to a store so that the
+// collector can answer usage queries.
+type printSink struct {
+	usageStore
+
+	out    io.Writer
+	format func(*pbmetering.Event) (string, error)
+	lock   sync.Mutex
+}
+
+func newPrintSink(out io.Writer, format func(*pbmetering.Event) (string, error), store usageStore) *printSink {
+	return &printSink{
+		usageStore: store,
+		out:        out,
+		format:     format,
+	}
+}
+
+func (s *printSink) Write(ctx context.Context, events []*pbmetering.Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, ev := range events {
+		line, err := s.format(ev)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(s.out, line)
+	}
+
+	return s.usageStore.Write(ctx, events)
+}
+
+func formatEventJSON(ev *pbmetering.Event) (string, error) {
+	data, err := protojson.Marshal(ev)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal event: %w", err)
+	}
+	return string(data), nil
+}
+
+func formatEventText(ev *pbmetering.Event) (string, error) {
+	out := &strings.Builder{}
+	if ev.Timestamp != nil {
+		out.WriteString(ev.Timestamp.AsTime().Format("2006-01-02T15:04:05.000Z07:00"))
+	} else {
+		out.WriteString("-")
+	}
+	fmt.Fprintf(out, " %s %s", valueOrDash(ev.Network), valueOrDash(ev.Endpoint))
+
+	for _, metric := range ev.Metrics {
+		fmt.Fprintf(out, " %s=%g", metric.Key, metric.Value)
+		if metric.Unit != "" {
+			fmt.Fprintf(out, "(%s)", metric.Unit)
+		}
+	}
+
+	writeField(out, "user", ev.UserId)
+	writeField(out, "api_key", ev.ApiKeyId)
+	writeField(out, "ip", ev.IpAddress)
+	writeField(out, "meta", ev.Meta)
+	writeField(out, "trace", ev.TraceId)
+	writeLabels(out, ev.Labels)
+
+	return out.String(), nil
+}
+
+func writeLabels(out *strings.Builder, labels map[string]string) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeField(out, "label."+key, labels[key])
+	}
+}
+
+func writeField(out *strings.Builder, name, value string) {
+	if value != "" {
+		fmt.Fprintf(out, " %s=%q", name, value)
+	}
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestFormatEventText(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  *pbmetering.Event
+		expect string
+	}{
+		{
+			name:   "minimal",
+			event:  &pbmetering.Event{Endpoint: "sf.firehose.v2/Blocks"},
+			expect: "- - sf.firehose.v2/Blocks",
+		},
+		{
+			name: "full",
+			event: &pbmetering.Event{
+				Endpoint:  "sf.firehose.v2/Blocks",
+				Network:   "eth-mainnet",
+				UserId:    "user.1",
+				ApiKeyId:  "key.1",
+				IpAddress: "10.0.0.1",
+				TraceId:   "0123456789abcdef0123456789abcdef",
+				Labels:    map[string]string{"z": "last", "a": "first"},
+				Metrics: []*pbmetering.Metric{
+					{Key: "read_bytes", Value: 10, Unit: "bytes"},
+					{Key: "custom", Value: 0.5},
+				},
+				Timestamp: timestamppb.New(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)),
+			},
+			expect: `2023-06-01T12:00:00.000Z eth-mainnet sf.firehose.v2/Blocks read_bytes=10(bytes) custom=0.5 user="user.1" api_key="key.1" ip="10.0.0.1" trace="0123456789abcdef0123456789abcdef" label.a="first" label.z="last"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, err := formatEventText(test.event)
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, line)
+		})
+	}
+}
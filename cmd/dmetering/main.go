@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/grpc"
+	"github.com/streamingfast/dmetering/logger"
+	"github.com/streamingfast/dmetering/pricing"
+	"github.com/streamingfast/dmetering/privacy"
+	"github.com/streamingfast/dmetering/router"
+	"github.com/streamingfast/dmetering/sampling"
+	"github.com/streamingfast/logging"
+)
+
+var zlog, _ = logging.ApplicationLogger("dmetering", "github.com/streamingfast/dmetering/cmd/dmetering")
+
+var rootCmd = &cobra.Command{
+	Use:          "dmetering",
+	Short:        "Send, collect and replay metering events",
+	SilenceUsage: true,
+}
+
+func init() {
+	dmetering.RegisterNull()
+	logger.Register()
+	grpc.Register()
+	sampling.Register()
+	router.Register()
+	privacy.Register()
+	pricing.Register()
+}
+
+func main() {
+	rootCmd.AddCommand(sendCmd, validateCmd, collectCmd, replayCmd, usageCmd, priceCmd)
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/dmetering/collector/boltstore"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/streamingfast/dmetering/pricing"
+)
+
+var priceCmd = &cobra.Command{
+	Use:   "price [<file>]",
+	Short: "Print the cost line items of recorded events according to a pricing config",
+	Long: strings.TrimSpace(`
+Print the cost line items of recorded events according to the pricing config
+given with --config. Events are read from a JSONL file as written by
+"collect --output json", use "-" to read from standard input, or from the
+database of "collect --store-path" given with --store-path.
+`),
+	Example: `  dmetering price --config prices.yaml --store-path usage.db --start 2023-06-01T00:00:00Z --end 2023-07-01T00:00:00Z`,
+	Args:    cobra.MaximumNArgs(1),
+	RunE:    runPrice,
+}
+
+func init() {
+	priceCmd.Flags().String("config", "", "Path of the YAML or JSON pricing config")
+	priceCmd.Flags().String("store-path", "", "Path of the database events are read from instead of a file")
+	priceCmd.Flags().String("start", "", "Only price stored events from this time (inclusive) in RFC3339 format")
+	priceCmd.Flags().String("end", "", "Only price stored events up to this time (exclusive) in RFC3339 format")
+	priceCmd.MarkFlagRequired("config")
+}
+
+func runPrice(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	configPath, _ := flags.GetString("config")
+	storePath, _ := flags.GetString("store-path")
+
+	if (storePath == "") == (len(args) == 0) {
+		return fmt.Errorf("expected either an events file or --store-path")
+	}
+
+	config, err := pricing.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	pricer, err := pricing.NewPricer(config)
+	if err != nil {
+		return err
+	}
+
+	calculator := pricer.NewCalculator()
+	addEvent := func(ev *pbmetering.Event) error {
+		calculator.AddEvent(ev)
+		return nil
+	}
+
+	if storePath != "" {
+		err = priceStoredEvents(cmd, storePath, addEvent)
+	} else {
+		err = priceEventsFile(args[0], addEvent)
+	}
+	if err != nil {
+		return err
+	}
+
+	writeLineItems(cmd.OutOrStdout(), calculator.LineItems(), pricer.Currency())
+	return nil
+}
+
+func priceStoredEvents(cmd *cobra.Command, storePath string, fn func(*pbmetering.Event) error) error {
+	var start, end time.Time
+	for _, name := range []string{"start", "end"} {
+		value, _ := cmd.Flags().GetString(name)
+		if value == "" {
+			continue
+		}
+
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid %s time %q: %w", name, value, err)
+		}
+		if name == "start" {
+			start = at
+		} else {
+			end = at
+		}
+	}
+
+	store, err := boltstore.Open(storePath, &boltstore.Config{}, zlog)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.Events(start, end, fn)
+}
+
+func priceEventsFile(path string, fn func(*pbmetering.Event) error) error {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("unable to open events file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	_, err := readEvents(in, fn)
+	return err
+}
+
+func writeLineItems(out io.Writer, items []pricing.LineItem, currency string) {
+	var total float64
+	for _, item := range items {
+		fmt.Fprintf(out, "%s %s %s %s quantity=%g cost=%.6f %s\n",
+			valueOrDash(item.UserID), valueOrDash(item.Network), valueOrDash(item.Endpoint), item.Metric,
+			item.Quantity, item.Cost, item.Currency,
+		)
+		total += item.Cost
+	}
+
+	fmt.Fprintf(out, "total cost=%.6f %s\n", total, currency)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/streamingfast/dmetering/pricing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteLineItems(t *testing.T) {
+	out := &bytes.Buffer{}
+	writeLineItems(out, []pricing.LineItem{
+		{UserID: "user.1", Network: "eth-mainnet", Endpoint: "sf.firehose.v2/Blocks", Metric: "read_bytes", Quantity: 2048, Cost: 0.5, Currency: "USD"},
+		{Endpoint: "sf.firehose.v2/Blocks", Metric: "message_count", Quantity: 10, Cost: 0.25, Currency: "USD"},
+	}, "USD")
+
+	assert.Equal(t, `user.1 eth-mainnet sf.firehose.v2/Blocks read_bytes quantity=2048 cost=0.500000 USD
+- - sf.firehose.v2/Blocks message_count quantity=10 cost=0.250000 USD
+total cost=0.750000 USD
+`, out.String())
+}
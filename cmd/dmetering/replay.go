@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/dmetering"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <file>",
+	Short: "Replay a JSONL file of events into the emitter described by --dsn",
+	Long: strings.TrimSpace(`
+Replay a JSONL file of events into the emitter described by --dsn, use "-" to read
+from standard input. Each line is a "sf.metering.v1.Event" in its JSON form, as
+written by "collect --output json". The network of replayed events is the one of
+the emitter, the one recorded in the file is ignored.
+
+Reading pauses while the emitter has --max-queued events waiting to be sent, and
+the command fails if the emitter reports dropped or undelivered events.
+`),
+	Args: cobra.ExactArgs(1),
+	RunE: runReplay,
+}
+
+func init() {
+	replayCmd.Flags().String("dsn", "", "DSN of the emitter receiving the events")
+	replayCmd.Flags().Int("max-queued", 1000, "Events the emitter may have waiting to be sent before reading pauses, 0 never pauses")
+	replayCmd.MarkFlagRequired("dsn")
+}
+
+func runReplay(cmd *cobra.Command, args []string) error {
+	dsn, _ := cmd.Flags().GetString("dsn")
+	maxQueued, _ := cmd.Flags().GetInt("max-queued")
+
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("unable to open events file: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	emitter, err := newEmitter(dsn)
+	if err != nil {
+		return err
+	}
+
+	count, err := replayEvents(cmd.Context(), in, emitter, maxQueued)
+	emitter.Shutdown(err)
+	if err != nil {
+		return err
+	}
+
+	if err := checkDelivered(emitter, count); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Replayed %d events to %s emitter\n", count, schemeOf(dsn))
+	return nil
+}
+
+const replayPollInterval = 10 * time.Millisecond
+
+// replayEvents emits every event read from in, it stops at the first line that
+// cannot be decoded, returning the count of events emitted so far. Emitters
+// reporting stats are not handed a new event while they have maxQueued events
+// or more waiting to be sent, since buffered emitters drop events rather than
+// block when their buffer is full.
+func replayEvents(ctx context.Context, in io.Reader, emitter dmetering.EventEmitter, maxQueued int) (count int, err error) {
+	return readEvents(in, func(pbev *pbmetering.Event) error {
+		if err := waitForQueue(ctx, emitter, maxQueued); err != nil {
+			return err
+		}
+
+		ev, _ := dmetering.EventFromProto(pbev)
+		emitter.Emit(ctx, ev)
+		return nil
+	})
+}
+
+func waitForQueue(ctx context.Context, emitter dmetering.EventEmitter, maxQueued int) error {
+	if maxQueued <= 0 {
+		return nil
+	}
+
+	for {
+		stats, ok := dmetering.EmitterStats(emitter)
+		if !ok || stats.Queued < maxQueued {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(replayPollInterval):
+		}
+	}
+}
+
+// checkDelivered returns an error if the emitter, once shut down, reports
+// events it dropped or failed to send.
+func checkDelivered(emitter dmetering.EventEmitter, count int) error {
+	stats, ok := dmetering.EmitterStats(emitter)
+	if !ok {
+		return nil
+	}
+
+	if lost := stats.Dropped + stats.Failed; lost > 0 {
+		return fmt.Errorf("%d of %d replayed events were not delivered (%d dropped, %d failed to send)", lost, count, stats.Dropped, stats.Failed)
+	}
+	return nil
+}
+
+// readEvents calls fn with every event of the JSONL in, it stops at the first
+// line that cannot be decoded or error of fn, returning the count of events
+// handled so far.
+func readEvents(in io.Reader, fn func(*pbmetering.Event) error) (count int, err error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+
+		pbev := &pbmetering.Event{}
+		if err := unmarshaler.Unmarshal(data, pbev); err != nil {
+			return count, fmt.Errorf("invalid event at line %d: %w", line, err)
+		}
+
+		if err := fn(pbev); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("unable to read events: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/collector"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestReplayEvents_CollectOutput(t *testing.T) {
+	timestamp := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	received := []*pbmetering.Event{
+		{
+			Endpoint:  "sf.firehose.v2/Blocks",
+			Network:   "eth-mainnet",
+			UserId:    "user.1",
+			Labels:    map[string]string{"module": "map_events"},
+			Metrics:   []*pbmetering.Metric{{Key: "read_bytes", Value: 10, Unit: "bytes"}},
+			Timestamp: timestamppb.New(timestamp),
+		},
+		{Endpoint: "sf.substreams.rpc.v2/Blocks"},
+	}
+
+	out := &bytes.Buffer{}
+	require.NoError(t, newPrintSink(out, formatEventJSON, collector.NewMemoryStore(0, nil)).Write(context.Background(), received))
+
+	emitter := dmeteringtest.NewRecorder()
+	count, err := replayEvents(context.Background(), out, emitter, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []dmetering.Event{
+		{
+			Endpoint:  "sf.firehose.v2/Blocks",
+			UserID:    "user.1",
+			Labels:    map[string]string{"module": "map_events"},
+			Metrics:   map[string]float64{"read_bytes": 10},
+			Timestamp: timestamp,
+		},
+		{Endpoint: "sf.substreams.rpc.v2/Blocks"},
+	}, emitter.Events())
+}
+
+func TestReplayEvents_InvalidLine(t *testing.T) {
+	in := strings.NewReader("{\"endpoint\":\"a\"}\n\n{\"endpoint\":\n")
+
+	emitter := dmeteringtest.NewRecorder()
+	count, err := replayEvents(context.Background(), in, emitter, 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid event at line 3")
+	assert.Equal(t, 1, count)
+}
+
+// drainingEmitter reports a queue that drains by one event each time its stats
+// are read.
+type drainingEmitter struct {
+	*dmeteringtest.Recorder
+	queued, maxSeen int
+	stats           dmetering.Stats
+}
+
+func (e *drainingEmitter) Emit(ctx context.Context, ev dmetering.Event) {
+	e.Recorder.Emit(ctx, ev)
+	e.queued++
+	if e.queued > e.maxSeen {
+		e.maxSeen = e.queued
+	}
+}
+
+func (e *drainingEmitter) Stats() dmetering.Stats {
+	stats := e.stats
+	stats.Queued = e.queued
+	if e.queued > 0 {
+		e.queued--
+	}
+	return stats
+}
+
+func (e *drainingEmitter) Healthy() error { return nil }
+
+func TestReplayEvents_Backpressure(t *testing.T) {
+	in := strings.Repeat(`{"endpoint": "sf.firehose.v2/Blocks"}`+"\n", 20)
+
+	emitter := &drainingEmitter{Recorder: dmeteringtest.NewRecorder()}
+	count, err := replayEvents(context.Background(), strings.NewReader(in), emitter, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 20, count)
+	assert.Len(t, emitter.Events(), 20)
+	assert.LessOrEqual(t, emitter.maxSeen, 3, "no event is emitted while the queue is full")
+}
+
+func TestCheckDelivered(t *testing.T) {
+	assert.NoError(t, checkDelivered(dmeteringtest.NewRecorder(), 10), "emitters without stats are trusted")
+	assert.NoError(t, checkDelivered(&drainingEmitter{stats: dmetering.Stats{Emitted: 10}}, 10))
+	assert.EqualError(t,
+		checkDelivered(&drainingEmitter{stats: dmetering.Stats{Emitted: 7, Dropped: 2, Failed: 1}}, 10),
+		"3 of 10 replayed events were not delivered (2 dropped, 1 failed to send)",
+	)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/dmetering"
+)
+
+var sendCmd = &cobra.Command{
+	Use:     "send",
+	Short:   "Send a single test event to the emitter described by --dsn",
+	Example: `  dmetering send --dsn "grpc://localhost:9010?network=eth-mainnet" --endpoint sf.firehose.v2/Blocks --metric read_bytes=10 --user user.1`,
+	Args:    cobra.NoArgs,
+	RunE:    runSend,
+}
+
+func init() {
+	sendCmd.Flags().String("dsn", "", "DSN of the emitter receiving the event")
+	sendCmd.Flags().String("endpoint", "", "Endpoint of the event")
+	sendCmd.Flags().StringArray("metric", nil, "Metric of the event in the form <key>=<value>, can be repeated")
+	sendCmd.Flags().StringArray("label", nil, "Label of the event in the form <key>=<value>, can be repeated")
+	sendCmd.Flags().String("user", "", "User ID of the event")
+	sendCmd.Flags().String("api-key", "", "API key ID of the event")
+	sendCmd.Flags().String("ip", "", "IP address of the event")
+	sendCmd.Flags().String("meta", "", "Free-form metadata of the event")
+	sendCmd.MarkFlagRequired("dsn")
+	sendCmd.MarkFlagRequired("endpoint")
+}
+
+func runSend(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+	dsn, _ := flags.GetString("dsn")
+	metricValues, _ := flags.GetStringArray("metric")
+	labelValues, _ := flags.GetStringArray("label")
+
+	metrics, err := parseMetrics(metricValues)
+	if err != nil {
+		return err
+	}
+
+	labels, err := parseKeyValues(labelValues)
+	if err != nil {
+		return fmt.Errorf("invalid label: %w", err)
+	}
+
+	ev := dmetering.Event{
+		Metrics:   metrics,
+		Labels:    labels,
+		Timestamp: time.Now(),
+	}
+	ev.Endpoint, _ = flags.GetString("endpoint")
+	ev.UserID, _ = flags.GetString("user")
+	ev.ApiKeyID, _ = flags.GetString("api-key")
+	ev.IpAddress, _ = flags.GetString("ip")
+	ev.Meta, _ = flags.GetString("meta")
+
+	emitter, err := newEmitter(dsn)
+	if err != nil {
+		return err
+	}
+
+	emitter.Emit(context.Background(), ev)
+	emitter.Shutdown(nil)
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Sent event to %s emitter\n", schemeOf(dsn))
+	return nil
+}
+
+func parseMetrics(values []string) (map[string]float64, error) {
+	pairs, err := parseKeyValues(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metric: %w", err)
+	}
+
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	metrics := make(map[string]float64, len(pairs))
+	for key, raw := range pairs {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric value %q for %q: %w", raw, key, err)
+		}
+		metrics[key] = value
+	}
+	return metrics, nil
+}
+
+func parseKeyValues(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, found := strings.Cut(value, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("%q is not in the form <key>=<value>", value)
+		}
+		out[key] = val
+	}
+	return out, nil
+}
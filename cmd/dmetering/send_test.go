@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		values      []string
+		expect      map[string]float64
+		expectError string
+	}{
+		{"none", nil, nil, ""},
+		{"single", []string{"read_bytes=10"}, map[string]float64{"read_bytes": 10}, ""},
+		{"multiple", []string{"read_bytes=10", "duration_seconds=1.5"}, map[string]float64{"read_bytes": 10, "duration_seconds": 1.5}, ""},
+		{"last wins", []string{"read_bytes=10", "read_bytes=20"}, map[string]float64{"read_bytes": 20}, ""},
+		{"missing value", []string{"read_bytes"}, nil, `invalid metric: "read_bytes" is not in the form <key>=<value>`},
+		{"missing key", []string{"=10"}, nil, `invalid metric: "=10" is not in the form <key>=<value>`},
+		{"invalid value", []string{"read_bytes=ten"}, nil, `invalid metric value "ten" for "read_bytes"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics, err := parseMetrics(test.values)
+			if test.expectError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, metrics)
+		})
+	}
+}
+
+func TestNewEmitter_UnknownPlugin(t *testing.T) {
+	_, err := newEmitter("unknown://")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no plugin named "unknown"`)
+
+	_, err = newEmitter("sampling://?rate=0.5&emitter=nope://")
+	require.Error(t, err, "nested DSNs are validated too")
+	assert.Contains(t, err.Error(), `no plugin named "nope"`)
+
+	emitter, err := newEmitter("null://")
+	require.NoError(t, err)
+	emitter.Shutdown(nil)
+}
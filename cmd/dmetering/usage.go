@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var usageCmd = &cobra.Command{
+	Use:     "usage",
+	Short:   "Query the usage aggregated by a plaintext collector",
+	Example: `  dmetering usage --addr localhost:9010 --user user.1 --group-by network,endpoint --granularity 1h --start 2023-06-01T00:00:00Z`,
+	Args:    cobra.NoArgs,
+	RunE:    runUsage,
+}
+
+var usageDimensions = map[string]pbmetering.UsageDimension{
+	"user":     pbmetering.UsageDimension_USAGE_DIMENSION_USER_ID,
+	"api-key":  pbmetering.UsageDimension_USAGE_DIMENSION_API_KEY_ID,
+	"network":  pbmetering.UsageDimension_USAGE_DIMENSION_NETWORK,
+	"endpoint": pbmetering.UsageDimension_USAGE_DIMENSION_ENDPOINT,
+}
+
+func init() {
+	usageCmd.Flags().String("addr", "localhost:9010", "Address of the collector")
+	usageCmd.Flags().String("user", "", "Only report usage of this user ID")
+	usageCmd.Flags().String("api-key", "", "Only report usage of this API key ID")
+	usageCmd.Flags().String("network", "", "Only report usage of this network")
+	usageCmd.Flags().String("endpoint", "", "Only report usage of this endpoint")
+	usageCmd.Flags().StringToString("label", nil, "Only report usage of events with this label value, as key=value, can be repeated")
+	usageCmd.Flags().String("start", "", "Start of the time range (inclusive) in RFC3339 format")
+	usageCmd.Flags().String("end", "", "End of the time range (exclusive) in RFC3339 format")
+	usageCmd.Flags().StringSlice("group-by", nil, "Dimensions usage is broken down by, any of user, api-key, network or endpoint")
+	usageCmd.Flags().StringSlice("group-by-label", nil, "Label keys usage is broken down by")
+	usageCmd.Flags().Duration("granularity", 0, "Size of the time buckets usage is reported in, a single bucket when 0")
+	usageCmd.Flags().StringArray("metric", nil, "Metric key to report, can be repeated, all of them when unset")
+	usageCmd.Flags().String("output", "text", "Output format of the usage records, one of text or json")
+}
+
+func runUsage(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+	addr, _ := flags.GetString("addr")
+	output, _ := flags.GetString("output")
+	if output != "text" && output != "json" {
+		return fmt.Errorf("invalid output value %q: expected one of %q or %q", output, "text", "json")
+	}
+
+	req, err := usageRequestFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("unable to dial collector %q: %w", addr, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resp, err := pbmetering.NewMeteringClient(conn).GetUsage(ctx, req)
+	if err != nil {
+		return fmt.Errorf("unable to query usage: %w", err)
+	}
+
+	for _, record := range resp.Records {
+		line, err := formatUsageText(record)
+		if output == "json" {
+			line, err = formatUsageJSON(record)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), line)
+	}
+	return nil
+}
+
+func usageRequestFromFlags(cmd *cobra.Command) (*pbmetering.UsageRequest, error) {
+	flags := cmd.Flags()
+
+	req := &pbmetering.UsageRequest{}
+	req.UserId, _ = flags.GetString("user")
+	req.ApiKeyId, _ = flags.GetString("api-key")
+	req.Network, _ = flags.GetString("network")
+	req.Endpoint, _ = flags.GetString("endpoint")
+	req.Metrics, _ = flags.GetStringArray("metric")
+	req.Labels, _ = flags.GetStringToString("label")
+	req.GroupByLabels, _ = flags.GetStringSlice("group-by-label")
+
+	for _, name := range []string{"start", "end"} {
+		value, _ := flags.GetString(name)
+		if value == "" {
+			continue
+		}
+
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s time %q: %w", name, value, err)
+		}
+		if name == "start" {
+			req.StartTime = timestamppb.New(at)
+		} else {
+			req.EndTime = timestamppb.New(at)
+		}
+	}
+
+	groupBy, _ := flags.GetStringSlice("group-by")
+	for _, name := range groupBy {
+		dimension, found := usageDimensions[name]
+		if !found {
+			return nil, fmt.Errorf("invalid group by dimension %q: expected one of user, api-key, network or endpoint", name)
+		}
+		req.GroupBy = append(req.GroupBy, dimension)
+	}
+
+	if granularity, _ := flags.GetDuration("granularity"); granularity > 0 {
+		req.Granularity = durationpb.New(granularity)
+	}
+
+	return req, nil
+}
+
+func formatUsageJSON(record *pbmetering.UsageRecord) (string, error) {
+	data, err := protojson.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal usage record: %w", err)
+	}
+	return string(data), nil
+}
+
+func formatUsageText(record *pbmetering.UsageRecord) (string, error) {
+	out := &strings.Builder{}
+	if record.BucketStart != nil {
+		out.WriteString(record.BucketStart.AsTime().Format("2006-01-02T15:04:05Z07:00"))
+	} else {
+		out.WriteString("-")
+	}
+	fmt.Fprintf(out, " events=%d", record.EventCount)
+
+	for _, metric := range record.Metrics {
+		fmt.Fprintf(out, " %s=%g", metric.Key, metric.Value)
+		if metric.Unit != "" {
+			fmt.Fprintf(out, "(%s)", metric.Unit)
+		}
+	}
+
+	writeField(out, "user", record.UserId)
+	writeField(out, "api_key", record.ApiKeyId)
+	writeField(out, "network", record.Network)
+	writeField(out, "endpoint", record.Endpoint)
+	writeLabels(out, record.Labels)
+
+	return out.String(), nil
+}
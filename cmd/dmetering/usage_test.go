@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestFormatUsageText(t *testing.T) {
+	tests := []struct {
+		name   string
+		record *pbmetering.UsageRecord
+		expect string
+	}{
+		{
+			name:   "totals",
+			record: &pbmetering.UsageRecord{EventCount: 3, Metrics: []*pbmetering.Metric{{Key: "read_bytes", Value: 30, Unit: "bytes"}}},
+			expect: "- events=3 read_bytes=30(bytes)",
+		},
+		{
+			name: "grouped",
+			record: &pbmetering.UsageRecord{
+				BucketStart: timestamppb.New(time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)),
+				UserId:      "user.1",
+				Network:     "eth-mainnet",
+				EventCount:  1,
+				Metrics:     []*pbmetering.Metric{{Key: "custom", Value: 0.5}},
+			},
+			expect: `2023-06-01T12:00:00Z events=1 custom=0.5 user="user.1" network="eth-mainnet"`,
+		},
+		{
+			name:   "labels",
+			record: &pbmetering.UsageRecord{EventCount: 2, Labels: map[string]string{"package": "uniswap", "module": "map_pools"}},
+			expect: `- events=2 label.module="map_pools" label.package="uniswap"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, err := formatUsageText(test.record)
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, line)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/streamingfast/dmetering"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate <dsn>",
+	Short: "Validate a DSN by creating, then shutting down, the emitter it describes",
+	Long: strings.TrimSpace(`
+Validate a DSN by creating, then shutting down, the emitter it describes. Nested
+DSNs (sampling, router, privacy ...) are validated along the way since their
+children are created too. No event is sent.
+`),
+	Args: cobra.ExactArgs(1),
+	RunE: runValidate,
+}
+
+func runValidate(cmd *cobra.Command, args []string) error {
+	emitter, err := newEmitter(args[0])
+	if err != nil {
+		return err
+	}
+	emitter.Shutdown(nil)
+
+	fmt.Fprintf(cmd.OutOrStdout(), "DSN is valid for the %s plugin\n", schemeOf(args[0]))
+	return nil
+}
+
+// newEmitter creates the emitter of dsn, along with its nested emitters.
+func newEmitter(dsn string) (dmetering.EventEmitter, error) {
+	emitter, err := dmetering.New(dsn, zlog)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DSN: %w", err)
+	}
+	return emitter, nil
+}
+
+func schemeOf(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme
+}
@@ -0,0 +1,391 @@
+// Package boltstore is a collector.Sink persisting received events in an
+// embedded bbolt database, along with the usage rollups GetUsage is answered
+// from, so that a collector needs no external database.
+package boltstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/dmetering/clock"
+	"github.com/streamingfast/dmetering/collector"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	eventsBucket  = []byte("events")
+	rollupsBucket = []byte("rollups")
+	metaBucket    = []byte("meta")
+
+	resolutionKey = []byte("resolution")
+)
+
+type Config struct {
+	// Resolution is the size of the time buckets usage is aggregated in. It's
+	// recorded when the database is created and Open fails when it differs from
+	// the recorded one, zero using the recorded one or
+	// collector.DefaultResolution for a new database.
+	Resolution time.Duration
+
+	// EventRetention is how long raw events are kept, forever when zero.
+	EventRetention time.Duration
+	// RollupRetention is how long rollups are kept, forever when zero. It's
+	// usually much longer than EventRetention, rollups being far smaller.
+	RollupRetention time.Duration
+
+	// CompactionInterval is the delay between two removals of the data past
+	// retention, Compact has to be called manually when zero.
+	CompactionInterval time.Duration
+
+	// Clock decides what is past retention and timestamps events received
+	// without one, it defaults to the wall clock.
+	Clock clock.Clock
+}
+
+// Store keeps raw events keyed by timestamp and rollups keyed by bucket start
+// in a single bbolt file. It implements collector.Sink and collector.UsageQuerier.
+type Store struct {
+	db     *bolt.DB
+	config Config
+	clock  clock.Clock
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	logger *zap.Logger
+}
+
+// Open opens, creating it if needed, the database at path. The Store must be
+// closed to release the file lock.
+func Open(path string, config *Config, logger *zap.Logger) (*Store, error) {
+	s := &Store{
+		config: *config,
+		clock:  clock.OrReal(config.Clock),
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database %q: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{eventsBucket, rollupsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return s.initResolution(tx.Bucket(metaBucket))
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to initialize database %q: %w", path, err)
+	}
+	s.db = db
+
+	if s.config.CompactionInterval > 0 {
+		s.wg.Add(1)
+		go s.compactLoop()
+	}
+
+	return s, nil
+}
+
+// initResolution records the configured resolution in a new database, or
+// checks it against the recorded one, as rollups of different sizes cannot be
+// told apart.
+func (s *Store) initResolution(meta *bolt.Bucket) error {
+	if value := meta.Get(resolutionKey); value != nil {
+		if len(value) != 8 {
+			return fmt.Errorf("invalid recorded resolution %x", value)
+		}
+
+		recorded := time.Duration(binary.BigEndian.Uint64(value))
+		if s.config.Resolution > 0 && s.config.Resolution != recorded {
+			return fmt.Errorf("resolution %s differs from the database's %s", s.config.Resolution, recorded)
+		}
+		s.config.Resolution = recorded
+		return nil
+	}
+
+	if s.config.Resolution <= 0 {
+		s.config.Resolution = collector.DefaultResolution
+	}
+	return meta.Put(resolutionKey, binary.BigEndian.AppendUint64(nil, uint64(s.config.Resolution)))
+}
+
+// Close stops the periodic compaction and closes the database.
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+
+	return s.db.Close()
+}
+
+// Write stores events and adds them to their rollup in a single transaction.
+func (s *Store) Write(_ context.Context, events []*pbmetering.Event) error {
+	now := s.clock.Now()
+
+	rollups := make(collector.Rollups)
+	for _, ev := range events {
+		rollups.Add(ev, s.config.Resolution, now)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		eventsBkt := tx.Bucket(eventsBucket)
+		for _, ev := range events {
+			seq, err := eventsBkt.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			value, err := proto.Marshal(ev)
+			if err != nil {
+				return fmt.Errorf("unable to marshal event: %w", err)
+			}
+
+			if err := eventsBkt.Put(eventKey(collector.EventTime(ev, now), seq), value); err != nil {
+				return err
+			}
+		}
+
+		rollupsBkt := tx.Bucket(rollupsBucket)
+		for key, rollup := range rollups {
+			dbKey := rollupKey(key)
+			if existing := rollupsBkt.Get(dbKey); existing != nil {
+				stored, err := decodeRollup(existing)
+				if err != nil {
+					return err
+				}
+				// The received usage is the most recent, gauges take its values
+				stored.Merge(rollup)
+				rollup = stored
+			}
+
+			value, err := encodeRollup(rollup)
+			if err != nil {
+				return err
+			}
+			if err := rollupsBkt.Put(dbKey, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Usage answers req from the stored rollups, only reading the ones in the
+// requested time range.
+func (s *Store) Usage(_ context.Context, req *pbmetering.UsageRequest) (*pbmetering.UsageResponse, error) {
+	aggregator, err := collector.NewUsageAggregator(req, s.config.Resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := aggregator.Range()
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return scanRange(tx.Bucket(rollupsBucket), start, end, func(_, value []byte) error {
+			rollup, err := decodeRollup(value)
+			if err != nil {
+				return err
+			}
+			aggregator.Add(rollup)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rollups: %w", err)
+	}
+
+	return aggregator.Response(), nil
+}
+
+// Events calls fn with every stored event whose timestamp is in [start, end),
+// in timestamp order. A zero value leaves that side unbounded. Events must not
+// be modified nor retained past the call, fn must not call back into the Store.
+func (s *Store) Events(start, end time.Time, fn func(*pbmetering.Event) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return scanRange(tx.Bucket(eventsBucket), start, end, func(_, value []byte) error {
+			ev := &pbmetering.Event{}
+			if err := proto.Unmarshal(value, ev); err != nil {
+				return fmt.Errorf("unable to unmarshal event: %w", err)
+			}
+			return fn(ev)
+		})
+	})
+}
+
+// Compact removes the events and rollups past their retention.
+func (s *Store) Compact() error {
+	now := s.clock.Now()
+
+	var deletedEvents, deletedRollups int
+	err := s.db.Update(func(tx *bolt.Tx) (err error) {
+		if s.config.EventRetention > 0 {
+			deletedEvents, err = deleteBefore(tx.Bucket(eventsBucket), now.Add(-s.config.EventRetention))
+			if err != nil {
+				return err
+			}
+		}
+		if s.config.RollupRetention > 0 {
+			// Only whole buckets are removed, the ones ending before the cutoff
+			cutoff := collector.AlignTime(now.Add(-s.config.RollupRetention), s.config.Resolution)
+			deletedRollups, err = deleteBefore(tx.Bucket(rollupsBucket), cutoff)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to compact database: %w", err)
+	}
+
+	s.logger.Debug("compacted database", zap.Int("deleted_events", deletedEvents), zap.Int("deleted_rollups", deletedRollups))
+	return nil
+}
+
+func (s *Store) compactLoop() {
+	defer s.wg.Done()
+
+	ticker := s.clock.NewTicker(s.config.CompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C():
+			if err := s.Compact(); err != nil {
+				s.logger.Warn("periodic compaction failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// scanRange calls fn for every key of bucket whose time prefix is in [start, end).
+func scanRange(bucket *bolt.Bucket, start, end time.Time, fn func(key, value []byte) error) error {
+	cursor := bucket.Cursor()
+
+	var key, value []byte
+	if start.IsZero() {
+		key, value = cursor.First()
+	} else {
+		key, value = cursor.Seek(timePrefix(start))
+	}
+
+	var endPrefix []byte
+	if !end.IsZero() {
+		endPrefix = timePrefix(end)
+	}
+
+	for ; key != nil; key, value = cursor.Next() {
+		if endPrefix != nil && bytes.Compare(key[:8], endPrefix) >= 0 {
+			return nil
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteBefore(bucket *bolt.Bucket, cutoff time.Time) (int, error) {
+	var keys [][]byte
+	err := scanRange(bucket, time.Time{}, cutoff, func(key, _ []byte) error {
+		keys = append(keys, append([]byte(nil), key...))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Deleting while iterating a bbolt cursor skips keys, hence the two passes
+	for _, key := range keys {
+		if err := bucket.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// timePrefix encodes t so that byte order matches time order, times before the
+// epoch included.
+func timePrefix(t time.Time) []byte {
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint64(prefix, uint64(t.UnixNano())^(1<<63))
+	return prefix
+}
+
+func eventKey(at time.Time, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(timePrefix(at), seq)
+}
+
+// rollupKey is the time prefix of the bucket followed by each dimension, the
+// canonical labels encoding last, prefixed by its length, so that no two keys
+// encode to the same bytes whatever the dimension values hold.
+func rollupKey(key collector.RollupKey) []byte {
+	out := timePrefix(key.BucketStart)
+	for _, dimension := range []string{key.UserID, key.ApiKeyID, key.Network, key.Endpoint, string(key.Labels)} {
+		out = binary.AppendUvarint(out, uint64(len(dimension)))
+		out = append(out, dimension...)
+	}
+	return out
+}
+
+// Rollups are stored as UsageRecord messages, which hold the same data.
+func encodeRollup(rollup *collector.Rollup) ([]byte, error) {
+	record := &pbmetering.UsageRecord{
+		BucketStart: timestamppb.New(rollup.BucketStart),
+		UserId:      rollup.UserID,
+		ApiKeyId:    rollup.ApiKeyID,
+		Network:     rollup.Network,
+		Endpoint:    rollup.Endpoint,
+		Labels:      rollup.Labels.Map(),
+		EventCount:  rollup.EventCount,
+		Metrics:     make([]*pbmetering.Metric, 0, len(rollup.Metrics)),
+	}
+	for key, value := range rollup.Metrics {
+		record.Metrics = append(record.Metrics, &pbmetering.Metric{Key: key, Value: value})
+	}
+
+	data, err := proto.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal rollup: %w", err)
+	}
+	return data, nil
+}
+
+func decodeRollup(data []byte) (*collector.Rollup, error) {
+	record := &pbmetering.UsageRecord{}
+	if err := proto.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal rollup: %w", err)
+	}
+
+	rollup := &collector.Rollup{
+		RollupKey: collector.RollupKey{
+			BucketStart: record.BucketStart.AsTime(),
+			UserID:      record.UserId,
+			ApiKeyID:    record.ApiKeyId,
+			Network:     record.Network,
+			Endpoint:    record.Endpoint,
+			Labels:      collector.EncodeLabels(record.Labels),
+		},
+		Metrics:    make(map[string]float64, len(record.Metrics)),
+		EventCount: record.EventCount,
+	}
+	for _, metric := range record.Metrics {
+		rollup.Metrics[metric.Key] += metric.Value
+	}
+	return rollup, nil
+}
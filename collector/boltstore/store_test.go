@@ -0,0 +1,234 @@
+package boltstore
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	"github.com/streamingfast/dmetering/collector"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var base = time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func testEvent(offset time.Duration, user, network string, readBytes float64) *pbmetering.Event {
+	return &pbmetering.Event{
+		UserId:    user,
+		ApiKeyId:  "key." + user,
+		Network:   network,
+		Endpoint:  "sf.firehose.v2/Blocks",
+		Timestamp: timestamppb.New(base.Add(offset)),
+		Metrics:   []*pbmetering.Metric{{Key: dmetering.MetricReadBytes, Value: readBytes}},
+	}
+}
+
+func withLabels(ev *pbmetering.Event, labels map[string]string) *pbmetering.Event {
+	ev.Labels = labels
+	return ev
+}
+
+var testEvents = []*pbmetering.Event{
+	testEvent(10*time.Second, "user.1", "eth-mainnet", 10),
+	testEvent(-time.Hour, "user.1", "eth-mainnet", 1),
+	testEvent(30*time.Second, "user.2", "sol-mainnet", 20),
+	testEvent(90*time.Second, "user.1", "eth-mainnet", 30),
+	testEvent(-25*time.Hour, "user.2", "eth-mainnet", 2),
+}
+
+var labeledEvents = []*pbmetering.Event{
+	withLabels(testEvent(20*time.Second, "user.1", "eth-mainnet", 5), map[string]string{"module": "map_transfers"}),
+	withLabels(testEvent(40*time.Second, "user.1", "eth-mainnet", 7), map[string]string{"module": "map_pools", "package": "uniswap"}),
+}
+
+func openStore(t *testing.T, path string, config *Config) *Store {
+	t.Helper()
+
+	store, err := Open(path, config, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStore_UsageMatchesMemoryStore(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "usage.db"), &Config{Resolution: time.Minute})
+	memory := collector.NewMemoryStore(time.Minute, nil)
+
+	// Written in two batches so that rollups are merged with stored ones
+	for _, batch := range [][]*pbmetering.Event{testEvents[:2], testEvents[2:], labeledEvents} {
+		require.NoError(t, store.Write(context.Background(), batch))
+		require.NoError(t, memory.Write(context.Background(), batch))
+	}
+
+	requests := []*pbmetering.UsageRequest{
+		{},
+		{UserId: "user.1", GroupBy: []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_NETWORK}},
+		{Granularity: durationpb.New(time.Hour), GroupBy: []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_USER_ID}},
+		{StartTime: timestamppb.New(base.Add(15 * time.Second)), EndTime: timestamppb.New(base.Add(time.Minute))},
+		{EndTime: timestamppb.New(base)},
+		{Labels: map[string]string{"module": "map_pools"}},
+		{GroupByLabels: []string{"module"}, GroupBy: []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_USER_ID}},
+	}
+
+	for _, req := range requests {
+		expected, err := memory.Usage(context.Background(), req)
+		require.NoError(t, err)
+
+		actual, err := store.Usage(context.Background(), req)
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(expected, actual), "request %s\nexpected %s\nactual %s", req, expected, actual)
+	}
+
+	resp, err := store.Usage(context.Background(), &pbmetering.UsageRequest{StartTime: timestamppb.New(base)})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, uint64(5), resp.Records[0].EventCount)
+	assert.Equal(t, float64(72), resp.Records[0].Metrics[0].Value)
+
+	resp, err = store.Usage(context.Background(), &pbmetering.UsageRequest{GroupByLabels: []string{"module"}, Labels: map[string]string{"package": ""}})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 2)
+	assert.Nil(t, resp.Records[0].Labels)
+	assert.Equal(t, float64(63), resp.Records[0].Metrics[0].Value)
+	assert.Equal(t, map[string]string{"module": "map_transfers"}, resp.Records[1].Labels)
+	assert.Equal(t, float64(5), resp.Records[1].Metrics[0].Value)
+}
+
+func TestStore_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.db")
+
+	store, err := Open(path, &Config{}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, store.Write(context.Background(), testEvents))
+	require.NoError(t, store.Close())
+
+	store = openStore(t, path, &Config{})
+	resp, err := store.Usage(context.Background(), &pbmetering.UsageRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, uint64(len(testEvents)), resp.Records[0].EventCount)
+}
+
+func TestStore_Resolution(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.db")
+
+	store, err := Open(path, &Config{Resolution: time.Hour}, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	_, err = Open(path, &Config{Resolution: time.Minute}, zap.NewNop())
+	assert.EqualError(t, err, fmt.Sprintf("unable to initialize database %q: resolution 1m0s differs from the database's 1h0m0s", path))
+
+	store = openStore(t, path, &Config{})
+	assert.Equal(t, time.Hour, store.config.Resolution, "zero resolution uses the recorded one")
+}
+
+func TestStore_Events(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "usage.db"), &Config{})
+	require.NoError(t, store.Write(context.Background(), testEvents))
+
+	var values []float64
+	require.NoError(t, store.Events(base.Add(-time.Hour), base.Add(time.Minute), func(ev *pbmetering.Event) error {
+		values = append(values, ev.Metrics[0].Value)
+		return nil
+	}))
+	assert.Equal(t, []float64{1, 10, 20}, values, "events in range in timestamp order")
+}
+
+func TestStore_Compact(t *testing.T) {
+	fake := clock.NewFake(base.Add(2 * time.Minute))
+	store := openStore(t, filepath.Join(t.TempDir(), "usage.db"), &Config{
+		EventRetention:     2 * time.Hour,
+		RollupRetention:    24 * time.Hour,
+		CompactionInterval: time.Hour,
+		Clock:              fake,
+	})
+	require.NoError(t, store.Write(context.Background(), testEvents))
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Hour)
+
+	// Compaction runs on the ticker goroutine, wait until it's visible
+	require.Eventually(t, func() bool {
+		resp, err := store.Usage(context.Background(), &pbmetering.UsageRequest{})
+		require.NoError(t, err)
+		return len(resp.Records) == 1 && resp.Records[0].EventCount == 4
+	}, 5*time.Second, 10*time.Millisecond, "rollup older than 24h is removed")
+
+	count := 0
+	require.NoError(t, store.Events(time.Time{}, time.Time{}, func(*pbmetering.Event) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 3, count, "events older than 2h are removed")
+}
+
+func TestStore_ZeroTimestamp(t *testing.T) {
+	fake := clock.NewFake(base)
+	store := openStore(t, filepath.Join(t.TempDir(), "usage.db"), &Config{EventRetention: time.Hour, Clock: fake})
+
+	ev := testEvent(0, "user.1", "eth-mainnet", 10)
+	ev.Timestamp = timestamppb.New(time.Time{})
+	require.NoError(t, store.Write(context.Background(), []*pbmetering.Event{ev}))
+	require.NoError(t, store.Compact())
+
+	count := 0
+	require.NoError(t, store.Events(base, base.Add(time.Second), func(*pbmetering.Event) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 1, count, "event without timestamp is stored at reception and kept by compaction")
+}
+
+func TestStore_Gauges(t *testing.T) {
+	const gauge = "boltstore_test_active_streams"
+	dmetering.RegisterMetric(dmetering.MetricDefinition{Name: gauge, Unit: dmetering.MetricUnitCount, Kind: dmetering.MetricKindGauge})
+
+	store := openStore(t, filepath.Join(t.TempDir(), "usage.db"), &Config{})
+	for _, streams := range []float64{4, 2} {
+		ev := testEvent(0, "user.1", "eth-mainnet", 10)
+		ev.Metrics = append(ev.Metrics, &pbmetering.Metric{Key: gauge, Value: streams})
+		require.NoError(t, store.Write(context.Background(), []*pbmetering.Event{ev}))
+	}
+
+	resp, err := store.Usage(context.Background(), &pbmetering.UsageRequest{Metrics: []string{gauge, dmetering.MetricReadBytes}})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, []*pbmetering.Metric{
+		{Key: gauge, Value: 2, Unit: string(dmetering.MetricUnitCount)},
+		{Key: dmetering.MetricReadBytes, Value: 20, Unit: string(dmetering.MetricUnitBytes)},
+	}, resp.Records[0].Metrics)
+}
+
+func TestRollupKey_Unambiguous(t *testing.T) {
+	left := rollupKey(collector.RollupKey{BucketStart: base, UserID: "a\x00b", ApiKeyID: "c"})
+	right := rollupKey(collector.RollupKey{BucketStart: base, UserID: "a", ApiKeyID: "b\x00c"})
+	assert.NotEqual(t, left, right)
+
+	left = rollupKey(collector.RollupKey{BucketStart: base, Endpoint: "e", Labels: collector.EncodeLabels(map[string]string{"k": "v"})})
+	right = rollupKey(collector.RollupKey{BucketStart: base, Endpoint: "e"})
+	assert.NotEqual(t, left, right)
+
+	store := openStore(t, filepath.Join(t.TempDir(), "usage.db"), &Config{})
+	first, second := testEvent(0, "a\x00b", "eth-mainnet", 10), testEvent(0, "a", "eth-mainnet", 20)
+	first.ApiKeyId, second.ApiKeyId = "c", "b\x00c"
+	require.NoError(t, store.Write(context.Background(), []*pbmetering.Event{first}))
+	require.NoError(t, store.Write(context.Background(), []*pbmetering.Event{second}))
+
+	resp, err := store.Usage(context.Background(), &pbmetering.UsageRequest{
+		GroupBy: []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_USER_ID},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 2)
+	assert.Equal(t, float64(20), resp.Records[0].Metrics[0].Value)
+	assert.Equal(t, float64(10), resp.Records[1].Metrics[0].Value)
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/dmetering/clock"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+)
+
+// DefaultResolution is the size of the time buckets usage is aggregated in when
+// a store is created with a zero resolution.
+const DefaultResolution = time.Minute
+
+// MemoryStore is a Sink aggregating received events into in-memory rollups and
+// answering usage queries from them. Rollups are never evicted, it's meant for
+// local development and tests.
+type MemoryStore struct {
+	resolution time.Duration
+	clock      clock.Clock
+
+	lock    sync.RWMutex
+	rollups Rollups
+}
+
+// NewMemoryStore returns a store aggregating events at the given resolution,
+// DefaultResolution when zero. Events received without a timestamp are
+// accounted at the time given by clk, the wall clock when nil.
+func NewMemoryStore(resolution time.Duration, clk clock.Clock) *MemoryStore {
+	if resolution <= 0 {
+		resolution = DefaultResolution
+	}
+
+	return &MemoryStore{
+		resolution: resolution,
+		clock:      clock.OrReal(clk),
+		rollups:    make(Rollups),
+	}
+}
+
+func (s *MemoryStore) Write(_ context.Context, events []*pbmetering.Event) error {
+	now := s.clock.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, ev := range events {
+		s.rollups.Add(ev, s.resolution, now)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Usage(_ context.Context, req *pbmetering.UsageRequest) (*pbmetering.UsageResponse, error) {
+	aggregator, err := NewUsageAggregator(req, s.resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, rollup := range s.rollups {
+		aggregator.Add(rollup)
+	}
+	return aggregator.Response(), nil
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"context"
+
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Sink receives the events accepted by a Server. A Sink must be safe for
+// concurrent use, Write is called from every in-flight Emit call.
+type Sink interface {
+	Write(ctx context.Context, events []*pbmetering.Event) error
+}
+
+// SinkFunc adapts a function to the Sink interface.
+type SinkFunc func(ctx context.Context, events []*pbmetering.Event) error
+
+func (f SinkFunc) Write(ctx context.Context, events []*pbmetering.Event) error {
+	return f(ctx, events)
+}
+
+// Server is a reference implementation of the `sf.metering.v1.Metering` service
+// handing every received batch to a Sink. GetUsage is served when the sink also
+// implements UsageQuerier. Register it on a gRPC server with
+// pbmetering.RegisterMeteringServer.
+type Server struct {
+	pbmetering.UnimplementedMeteringServer
+
+	sink   Sink
+	logger *zap.Logger
+}
+
+func NewServer(sink Sink, logger *zap.Logger) *Server {
+	return &Server{
+		sink:   sink,
+		logger: logger,
+	}
+}
+
+func (s *Server) Emit(ctx context.Context, events *pbmetering.Events) (*emptypb.Empty, error) {
+	if len(events.Events) == 0 {
+		return &emptypb.Empty{}, nil
+	}
+
+	if err := s.sink.Write(ctx, events.Events); err != nil {
+		s.logger.Warn("unable to write received events to sink", zap.Int("count", len(events.Events)), zap.Error(err))
+		return nil, status.Errorf(codes.Unavailable, "unable to write events: %s", err)
+	}
+
+	s.logger.Debug("received events", zap.Int("count", len(events.Events)))
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) GetUsage(ctx context.Context, req *pbmetering.UsageRequest) (*pbmetering.UsageResponse, error) {
+	querier, ok := s.sink.(UsageQuerier)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "collector sink does not keep aggregated usage")
+	}
+
+	resp, err := querier.Usage(ctx, req)
+	if err != nil {
+		if _, isStatus := status.FromError(err); isStatus {
+			return nil, err
+		}
+
+		s.logger.Warn("unable to query usage", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "unable to query usage: %s", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	dmeteringgrpc "github.com/streamingfast/dmetering/grpc"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func startServer(t *testing.T, sink Sink) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	pbmetering.RegisterMeteringServer(server, NewServer(sink, zap.NewNop()))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String()
+}
+
+func TestServer_ReceivesEmitterEvents(t *testing.T) {
+	var lock sync.Mutex
+	var received []*pbmetering.Event
+	addr := startServer(t, SinkFunc(func(_ context.Context, events []*pbmetering.Event) error {
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, events...)
+		return nil
+	}))
+
+	emitter, err := dmeteringgrpc.NewEmitter(&dmeteringgrpc.Config{
+		Endpoint:        addr,
+		Network:         "eth-mainnet",
+		Transport:       dmeteringgrpc.TransportPlaintext,
+		Delay:           10 * time.Millisecond,
+		BufferSize:      10,
+		ShutdownTimeout: 5 * time.Second,
+		EmitTimeout:     5 * time.Second,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	emitter.Emit(context.Background(), dmetering.Event{
+		Endpoint: "sf.firehose.v2/Blocks",
+		UserID:   "user.1",
+		Metrics:  map[string]float64{dmetering.MetricReadBytes: 10},
+	})
+	emitter.Shutdown(nil)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, received, 1)
+	assert.Equal(t, "sf.firehose.v2/Blocks", received[0].Endpoint)
+	assert.Equal(t, "eth-mainnet", received[0].Network)
+	assert.Equal(t, "user.1", received[0].UserId)
+}
+
+func TestServer_SinkError(t *testing.T) {
+	addr := startServer(t, SinkFunc(func(_ context.Context, _ []*pbmetering.Event) error {
+		return errors.New("disk full")
+	}))
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = pbmetering.NewMeteringClient(conn).Emit(context.Background(), &pbmetering.Events{
+		Events: []*pbmetering.Event{{Endpoint: "sf.firehose.v2/Blocks"}},
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestServer_GetUsage(t *testing.T) {
+	store := NewMemoryStore(time.Minute, nil)
+	conn, err := grpc.Dial(startServer(t, store), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := pbmetering.NewMeteringClient(conn)
+	_, err = client.Emit(context.Background(), &pbmetering.Events{Events: []*pbmetering.Event{
+		usageEvent(0, "user.1", "key.1", "eth-mainnet", "sf.firehose.v2/Blocks", 10),
+		usageEvent(0, "user.1", "key.1", "eth-mainnet", "sf.firehose.v2/Blocks", 5),
+	}})
+	require.NoError(t, err)
+
+	resp, err := client.GetUsage(context.Background(), &pbmetering.UsageRequest{
+		GroupBy: []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_USER_ID},
+		Metrics: []string{dmetering.MetricReadBytes},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []usageRow{
+		{user: "user.1", metrics: map[string]float64{dmetering.MetricReadBytes: 15}, events: 2},
+	}, usageRows(resp))
+
+	_, err = client.GetUsage(context.Background(), &pbmetering.UsageRequest{Granularity: durationpb.New(time.Second)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_GetUsage_Unimplemented(t *testing.T) {
+	addr := startServer(t, SinkFunc(func(_ context.Context, _ []*pbmetering.Event) error { return nil }))
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = pbmetering.NewMeteringClient(conn).GetUsage(context.Background(), &pbmetering.UsageRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
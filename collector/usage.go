@@ -0,0 +1,431 @@
+package collector
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// UsageQuerier is implemented by sinks keeping aggregated usage, a Server whose
+// sink implements it serves GetUsage from it. Errors returned as gRPC statuses
+// are passed as is to the caller.
+type UsageQuerier interface {
+	Usage(ctx context.Context, req *pbmetering.UsageRequest) (*pbmetering.UsageResponse, error)
+}
+
+// RollupKey identifies the usage of a user's API key on an endpoint of a
+// network with a set of labels during the time bucket starting at BucketStart.
+type RollupKey struct {
+	BucketStart time.Time
+	UserID      string
+	ApiKeyID    string
+	Network     string
+	Endpoint    string
+	Labels      Labels
+}
+
+// Labels is the canonical encoding of a set of labels, comparable so that it
+// can be part of a map key: labels sorted by key, each key and value prefixed
+// by its length. Labels with an empty value are left out.
+type Labels string
+
+func EncodeLabels(labels map[string]string) Labels {
+	keys := make([]string, 0, len(labels))
+	for key, value := range labels {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+
+	var out []byte
+	for _, key := range keys {
+		out = binary.AppendUvarint(out, uint64(len(key)))
+		out = append(out, key...)
+		out = binary.AppendUvarint(out, uint64(len(labels[key])))
+		out = append(out, labels[key]...)
+	}
+	return Labels(out)
+}
+
+// Map decodes the labels, it returns nil when there are none.
+func (l Labels) Map() map[string]string {
+	if l == "" {
+		return nil
+	}
+
+	out := make(map[string]string)
+	for in := string(l); in != ""; {
+		key, rest := readLabelField(in)
+		value, rest := readLabelField(rest)
+		out[key] = value
+		in = rest
+	}
+	return out
+}
+
+// Get returns the value of the label key, empty when the label is not set.
+func (l Labels) Get(key string) string {
+	for in := string(l); in != ""; {
+		labelKey, rest := readLabelField(in)
+		value, rest := readLabelField(rest)
+		if labelKey == key {
+			return value
+		}
+		in = rest
+	}
+	return ""
+}
+
+// readLabelField reads a length prefixed field of in, a truncated field ends
+// the input.
+func readLabelField(in string) (field, rest string) {
+	length, n := binary.Uvarint([]byte(in))
+	if n <= 0 || uint64(len(in)-n) < length {
+		return "", ""
+	}
+	return in[n : n+int(length)], in[n+int(length):]
+}
+
+// Rollup is the usage totaled over the events of a RollupKey.
+type Rollup struct {
+	RollupKey
+
+	Metrics    map[string]float64
+	EventCount uint64
+}
+
+// Merge adds the metrics and event count of other, the more recent usage, to
+// r. Gauge metrics take the value of other as they keep the last value when
+// aggregated.
+func (r *Rollup) Merge(other *Rollup) {
+	if r.Metrics == nil {
+		r.Metrics = make(map[string]float64, len(other.Metrics))
+	}
+	for key, value := range other.Metrics {
+		mergeMetric(r.Metrics, key, value)
+	}
+	r.EventCount += other.EventCount
+}
+
+// mergeMetric adds value to metrics[key] according to the kind of the metric,
+// unknown metrics being counters.
+func mergeMetric(metrics map[string]float64, key string, value float64) {
+	if isGauge(key) {
+		metrics[key] = value
+		return
+	}
+	metrics[key] += value
+}
+
+func isGauge(metric string) bool {
+	def, found := dmetering.LookupMetric(metric)
+	return found && def.Kind == dmetering.MetricKindGauge
+}
+
+// Rollups accumulates events into rollups keyed by time bucket and dimensions.
+type Rollups map[RollupKey]*Rollup
+
+// Add accounts ev in the rollup of its bucket of the given resolution, at the
+// time given by EventTime.
+func (r Rollups) Add(ev *pbmetering.Event, resolution time.Duration, receivedAt time.Time) {
+	key := RollupKey{
+		BucketStart: AlignTime(EventTime(ev, receivedAt), resolution),
+		UserID:      ev.UserId,
+		ApiKeyID:    ev.ApiKeyId,
+		Network:     ev.Network,
+		Endpoint:    ev.Endpoint,
+		Labels:      EncodeLabels(ev.Labels),
+	}
+
+	rollup := r[key]
+	if rollup == nil {
+		rollup = &Rollup{RollupKey: key, Metrics: make(map[string]float64, len(ev.Metrics))}
+		r[key] = rollup
+	}
+
+	for _, metric := range ev.Metrics {
+		mergeMetric(rollup.Metrics, metric.Key, metric.Value)
+	}
+	rollup.EventCount++
+}
+
+var (
+	minEventTime = time.Unix(0, math.MinInt64)
+	maxEventTime = time.Unix(0, math.MaxInt64)
+)
+
+// EventTime returns the timestamp of ev, or receivedAt when it has none. A zero
+// timestamp, which Event.ToProto sends for events emitted without one, counts
+// as none, as well as timestamps Unix nanoseconds cannot represent.
+func EventTime(ev *pbmetering.Event, receivedAt time.Time) time.Time {
+	if ev.Timestamp == nil {
+		return receivedAt
+	}
+
+	at := ev.Timestamp.AsTime()
+	if at.IsZero() || at.Before(minEventTime) || at.After(maxEventTime) {
+		return receivedAt
+	}
+	return at
+}
+
+// AlignTime returns the start of the bucket of size d holding t, buckets being
+// aligned on the Unix epoch. The result is in UTC.
+func AlignTime(t time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return t.UTC()
+	}
+
+	ns := t.UnixNano()
+	offset := ns % int64(d)
+	if offset < 0 {
+		offset += int64(d)
+	}
+	return time.Unix(0, ns-offset).UTC()
+}
+
+// UsageAggregator folds the rollups of a store into the records answering a
+// UsageRequest. Stores feed it every rollup overlapping Range, it applies the
+// remaining filters itself. Gauge metrics keep the value of the last rollup in
+// bucket start then dimension values order, whatever the order rollups are fed
+// in.
+type UsageAggregator struct {
+	req        *pbmetering.UsageRequest
+	resolution time.Duration
+
+	start, end  time.Time
+	granularity time.Duration
+	metrics     map[string]bool
+
+	records map[RollupKey]*Rollup
+	// gaugesFrom holds the rollup the value kept for each gauge of a record comes from
+	gaugesFrom map[RollupKey]map[string]RollupKey
+}
+
+// NewUsageAggregator validates req against a store keeping rollups of the given
+// resolution, DefaultResolution when zero, invalid requests are reported as
+// codes.InvalidArgument statuses.
+func NewUsageAggregator(req *pbmetering.UsageRequest, resolution time.Duration) (*UsageAggregator, error) {
+	if resolution <= 0 {
+		resolution = DefaultResolution
+	}
+
+	a := &UsageAggregator{
+		req:        req,
+		resolution: resolution,
+		records:    make(map[RollupKey]*Rollup),
+		gaugesFrom: make(map[RollupKey]map[string]RollupKey),
+	}
+
+	if req.StartTime != nil {
+		a.start = req.StartTime.AsTime()
+	}
+	if req.EndTime != nil {
+		a.end = req.EndTime.AsTime()
+	}
+	if !a.start.IsZero() && !a.end.IsZero() && a.end.Before(a.start) {
+		return nil, status.Errorf(codes.InvalidArgument, "end time %s is before start time %s", a.end, a.start)
+	}
+
+	if req.Granularity != nil {
+		a.granularity = req.Granularity.AsDuration()
+		if a.granularity < resolution || a.granularity%resolution != 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "granularity %s must be a multiple of the store resolution %s", a.granularity, resolution)
+		}
+	}
+
+	for _, dimension := range req.GroupBy {
+		switch dimension {
+		case pbmetering.UsageDimension_USAGE_DIMENSION_USER_ID,
+			pbmetering.UsageDimension_USAGE_DIMENSION_API_KEY_ID,
+			pbmetering.UsageDimension_USAGE_DIMENSION_NETWORK,
+			pbmetering.UsageDimension_USAGE_DIMENSION_ENDPOINT:
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "invalid group by dimension %s", dimension)
+		}
+	}
+
+	if len(req.Metrics) > 0 {
+		a.metrics = make(map[string]bool, len(req.Metrics))
+		for _, metric := range req.Metrics {
+			a.metrics[metric] = true
+		}
+	}
+
+	return a, nil
+}
+
+// Range returns the bucket starts of the rollups that may be part of the
+// answer, in [start, end). A zero value leaves that side unbounded.
+func (a *UsageAggregator) Range() (start, end time.Time) {
+	if !a.start.IsZero() {
+		start = AlignTime(a.start, a.resolution)
+	}
+	return start, a.end
+}
+
+// Add accounts rollup if it matches the request filters.
+func (a *UsageAggregator) Add(rollup *Rollup) {
+	if !a.matches(rollup) {
+		return
+	}
+
+	key := RollupKey{}
+	if a.granularity > 0 {
+		key.BucketStart = AlignTime(rollup.BucketStart, a.granularity)
+	}
+	if len(a.req.GroupByLabels) > 0 {
+		labels := make(map[string]string, len(a.req.GroupByLabels))
+		for _, label := range a.req.GroupByLabels {
+			labels[label] = rollup.Labels.Get(label)
+		}
+		key.Labels = EncodeLabels(labels)
+	}
+	for _, dimension := range a.req.GroupBy {
+		switch dimension {
+		case pbmetering.UsageDimension_USAGE_DIMENSION_USER_ID:
+			key.UserID = rollup.UserID
+		case pbmetering.UsageDimension_USAGE_DIMENSION_API_KEY_ID:
+			key.ApiKeyID = rollup.ApiKeyID
+		case pbmetering.UsageDimension_USAGE_DIMENSION_NETWORK:
+			key.Network = rollup.Network
+		case pbmetering.UsageDimension_USAGE_DIMENSION_ENDPOINT:
+			key.Endpoint = rollup.Endpoint
+		}
+	}
+
+	record := a.records[key]
+	if record == nil {
+		record = &Rollup{RollupKey: key, Metrics: make(map[string]float64)}
+		a.records[key] = record
+	}
+
+	for metric, value := range rollup.Metrics {
+		if a.metrics != nil && !a.metrics[metric] {
+			continue
+		}
+
+		if !isGauge(metric) {
+			record.Metrics[metric] += value
+			continue
+		}
+
+		gaugesFrom := a.gaugesFrom[key]
+		if gaugesFrom == nil {
+			gaugesFrom = make(map[string]RollupKey)
+			a.gaugesFrom[key] = gaugesFrom
+		}
+		if from, found := gaugesFrom[metric]; !found || !rollupKeyLess(rollup.RollupKey, from) {
+			record.Metrics[metric] = value
+			gaugesFrom[metric] = rollup.RollupKey
+		}
+	}
+	record.EventCount += rollup.EventCount
+}
+
+func (a *UsageAggregator) matches(rollup *Rollup) bool {
+	if a.req.UserId != "" && rollup.UserID != a.req.UserId {
+		return false
+	}
+	if a.req.ApiKeyId != "" && rollup.ApiKeyID != a.req.ApiKeyId {
+		return false
+	}
+	if a.req.Network != "" && rollup.Network != a.req.Network {
+		return false
+	}
+	if a.req.Endpoint != "" && rollup.Endpoint != a.req.Endpoint {
+		return false
+	}
+	for label, value := range a.req.Labels {
+		if rollup.Labels.Get(label) != value {
+			return false
+		}
+	}
+
+	start, end := a.Range()
+	if !start.IsZero() && rollup.BucketStart.Before(start) {
+		return false
+	}
+	if !end.IsZero() && !rollup.BucketStart.Before(end) {
+		return false
+	}
+	return true
+}
+
+// Response returns the records accumulated so far, sorted by bucket start then
+// by dimension values.
+func (a *UsageAggregator) Response() *pbmetering.UsageResponse {
+	keys := make([]RollupKey, 0, len(a.records))
+	for key := range a.records {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return rollupKeyLess(keys[i], keys[j]) })
+
+	resp := &pbmetering.UsageResponse{Records: make([]*pbmetering.UsageRecord, 0, len(keys))}
+	for _, key := range keys {
+		resp.Records = append(resp.Records, a.records[key].toProto())
+	}
+	return resp
+}
+
+// rollupKeyLess orders keys by bucket start then by dimension values.
+func rollupKeyLess(left, right RollupKey) bool {
+	if !left.BucketStart.Equal(right.BucketStart) {
+		return left.BucketStart.Before(right.BucketStart)
+	}
+	if left.UserID != right.UserID {
+		return left.UserID < right.UserID
+	}
+	if left.ApiKeyID != right.ApiKeyID {
+		return left.ApiKeyID < right.ApiKeyID
+	}
+	if left.Network != right.Network {
+		return left.Network < right.Network
+	}
+	if left.Endpoint != right.Endpoint {
+		return left.Endpoint < right.Endpoint
+	}
+	return left.Labels < right.Labels
+}
+
+func (r *Rollup) toProto() *pbmetering.UsageRecord {
+	record := &pbmetering.UsageRecord{
+		UserId:     r.UserID,
+		ApiKeyId:   r.ApiKeyID,
+		Network:    r.Network,
+		Endpoint:   r.Endpoint,
+		Labels:     r.Labels.Map(),
+		EventCount: r.EventCount,
+	}
+	if !r.BucketStart.IsZero() {
+		record.BucketStart = timestamppb.New(r.BucketStart)
+	}
+
+	keys := make([]string, 0, len(r.Metrics))
+	for key := range r.Metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	record.Metrics = make([]*pbmetering.Metric, 0, len(keys))
+	for _, key := range keys {
+		metric := &pbmetering.Metric{Key: key, Value: r.Metrics[key]}
+		if def, found := dmetering.LookupMetric(key); found {
+			metric.Unit = string(def.Unit)
+		}
+		record.Metrics = append(record.Metrics, metric)
+	}
+	return record
+}
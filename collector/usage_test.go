@@ -0,0 +1,325 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var usageBase = time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func usageEvent(offset time.Duration, user, key, network, endpoint string, readBytes float64) *pbmetering.Event {
+	return &pbmetering.Event{
+		UserId:    user,
+		ApiKeyId:  key,
+		Network:   network,
+		Endpoint:  endpoint,
+		Timestamp: timestamppb.New(usageBase.Add(offset)),
+		Metrics: []*pbmetering.Metric{
+			{Key: dmetering.MetricReadBytes, Value: readBytes},
+			{Key: dmetering.MetricMessageCount, Value: 1},
+		},
+	}
+}
+
+func newTestStore(t *testing.T) *MemoryStore {
+	t.Helper()
+
+	store := NewMemoryStore(time.Minute, nil)
+	require.NoError(t, store.Write(context.Background(), []*pbmetering.Event{
+		usageEvent(10*time.Second, "user.1", "key.1", "eth-mainnet", "sf.firehose.v2/Blocks", 10),
+		usageEvent(20*time.Second, "user.1", "key.2", "eth-mainnet", "sf.firehose.v2/Blocks", 20),
+		usageEvent(90*time.Second, "user.1", "key.1", "sol-mainnet", "sf.substreams.rpc.v2/Blocks", 30),
+		usageEvent(5*time.Minute, "user.2", "key.3", "eth-mainnet", "sf.firehose.v2/Blocks", 40),
+	}))
+	return store
+}
+
+type usageRow struct {
+	bucket    time.Time
+	user, key string
+	network   string
+	endpoint  string
+	metrics   map[string]float64
+	events    uint64
+}
+
+func usageRows(resp *pbmetering.UsageResponse) (out []usageRow) {
+	for _, record := range resp.Records {
+		row := usageRow{
+			user:     record.UserId,
+			key:      record.ApiKeyId,
+			network:  record.Network,
+			endpoint: record.Endpoint,
+			metrics:  map[string]float64{},
+			events:   record.EventCount,
+		}
+		if record.BucketStart != nil {
+			row.bucket = record.BucketStart.AsTime()
+		}
+		for _, metric := range record.Metrics {
+			row.metrics[metric.Key] = metric.Value
+		}
+		out = append(out, row)
+	}
+	return
+}
+
+func TestMemoryStore_Usage(t *testing.T) {
+	store := newTestStore(t)
+	readBytes := dmetering.MetricReadBytes
+
+	tests := []struct {
+		name   string
+		req    *pbmetering.UsageRequest
+		expect []usageRow
+	}{
+		{
+			name: "totals",
+			req:  &pbmetering.UsageRequest{Metrics: []string{readBytes}},
+			expect: []usageRow{
+				{metrics: map[string]float64{readBytes: 100}, events: 4},
+			},
+		},
+		{
+			name: "filtered by user grouped by network",
+			req: &pbmetering.UsageRequest{
+				UserId:  "user.1",
+				GroupBy: []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_NETWORK},
+				Metrics: []string{readBytes},
+			},
+			expect: []usageRow{
+				{network: "eth-mainnet", metrics: map[string]float64{readBytes: 30}, events: 2},
+				{network: "sol-mainnet", metrics: map[string]float64{readBytes: 30}, events: 1},
+			},
+		},
+		{
+			name: "granularity and group by api key",
+			req: &pbmetering.UsageRequest{
+				GroupBy:     []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_API_KEY_ID},
+				Granularity: durationpb.New(2 * time.Minute),
+				Metrics:     []string{readBytes},
+			},
+			expect: []usageRow{
+				{bucket: usageBase, key: "key.1", metrics: map[string]float64{readBytes: 40}, events: 2},
+				{bucket: usageBase, key: "key.2", metrics: map[string]float64{readBytes: 20}, events: 1},
+				{bucket: usageBase.Add(4 * time.Minute), key: "key.3", metrics: map[string]float64{readBytes: 40}, events: 1},
+			},
+		},
+		{
+			name: "time range applied at store resolution",
+			req: &pbmetering.UsageRequest{
+				StartTime: timestamppb.New(usageBase.Add(30 * time.Second)),
+				EndTime:   timestamppb.New(usageBase.Add(2 * time.Minute)),
+				Metrics:   []string{readBytes},
+			},
+			expect: []usageRow{
+				{metrics: map[string]float64{readBytes: 60}, events: 3},
+			},
+		},
+		{
+			name: "all metrics",
+			req:  &pbmetering.UsageRequest{Endpoint: "sf.substreams.rpc.v2/Blocks"},
+			expect: []usageRow{
+				{metrics: map[string]float64{readBytes: 30, dmetering.MetricMessageCount: 1}, events: 1},
+			},
+		},
+		{
+			name:   "no match",
+			req:    &pbmetering.UsageRequest{UserId: "unknown"},
+			expect: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := store.Usage(context.Background(), test.req)
+			require.NoError(t, err)
+			assert.Equal(t, test.expect, usageRows(resp))
+		})
+	}
+}
+
+func TestMemoryStore_Usage_Units(t *testing.T) {
+	resp, err := newTestStore(t).Usage(context.Background(), &pbmetering.UsageRequest{Metrics: []string{dmetering.MetricReadBytes}})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	require.Len(t, resp.Records[0].Metrics, 1)
+	assert.Equal(t, string(dmetering.MetricUnitBytes), resp.Records[0].Metrics[0].Unit)
+}
+
+func TestMemoryStore_Usage_InvalidRequest(t *testing.T) {
+	store := newTestStore(t)
+
+	tests := []struct {
+		name string
+		req  *pbmetering.UsageRequest
+	}{
+		{"end before start", &pbmetering.UsageRequest{
+			StartTime: timestamppb.New(usageBase),
+			EndTime:   timestamppb.New(usageBase.Add(-time.Second)),
+		}},
+		{"granularity finer than resolution", &pbmetering.UsageRequest{Granularity: durationpb.New(time.Second)}},
+		{"granularity not a multiple of resolution", &pbmetering.UsageRequest{Granularity: durationpb.New(90 * time.Second)}},
+		{"unspecified dimension", &pbmetering.UsageRequest{GroupBy: []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_UNSPECIFIED}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := store.Usage(context.Background(), test.req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+}
+
+func TestNewUsageAggregator_ZeroResolution(t *testing.T) {
+	aggregator, err := NewUsageAggregator(&pbmetering.UsageRequest{Granularity: durationpb.New(time.Hour)}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultResolution, aggregator.resolution)
+
+	_, err = NewUsageAggregator(&pbmetering.UsageRequest{Granularity: durationpb.New(90 * time.Second)}, 0)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAlignTime(t *testing.T) {
+	assert.Equal(t, usageBase, AlignTime(usageBase.Add(59*time.Second), time.Minute))
+	assert.Equal(t, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), AlignTime(usageBase.Add(72*time.Hour), 7*24*time.Hour), "weeks are aligned on the epoch, a Thursday")
+	assert.Equal(t, time.Unix(-60, 0).UTC(), AlignTime(time.Unix(-1, 0), time.Minute))
+}
+
+func TestEventTime(t *testing.T) {
+	receivedAt := usageBase.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		timestamp *timestamppb.Timestamp
+		expect    time.Time
+	}{
+		{"set", timestamppb.New(usageBase), usageBase},
+		{"nil", nil, receivedAt},
+		{"zero time", timestamppb.New(time.Time{}), receivedAt},
+		{"before unix nanoseconds range", timestamppb.New(time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)), receivedAt},
+		{"after unix nanoseconds range", timestamppb.New(time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC)), receivedAt},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, EventTime(&pbmetering.Event{Timestamp: test.timestamp}, receivedAt))
+		})
+	}
+}
+
+func TestRollups_ZeroTimestamp(t *testing.T) {
+	ev := dmetering.Event{Endpoint: "sf.firehose.v2/Blocks", Metrics: map[string]float64{dmetering.MetricReadBytes: 1}}.ToProto("eth-mainnet")
+
+	rollups := Rollups{}
+	rollups.Add(ev, time.Minute, usageBase.Add(10*time.Second))
+	for key := range rollups {
+		assert.Equal(t, usageBase, key.BucketStart, "event without timestamp is accounted at reception")
+	}
+}
+
+func TestMemoryStore_ZeroTimestamp(t *testing.T) {
+	store := NewMemoryStore(time.Minute, clock.NewFake(usageBase.Add(10*time.Second)))
+	ev := dmetering.Event{Endpoint: "sf.firehose.v2/Blocks", Metrics: map[string]float64{dmetering.MetricReadBytes: 1}}.ToProto("eth-mainnet")
+	require.NoError(t, store.Write(context.Background(), []*pbmetering.Event{ev}))
+
+	resp, err := store.Usage(context.Background(), &pbmetering.UsageRequest{Granularity: durationpb.New(time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, usageBase, resp.Records[0].BucketStart.AsTime(), "event without timestamp is accounted at the clock's time")
+}
+
+func TestMemoryStore_Usage_Gauges(t *testing.T) {
+	const gauge = "collector_test_active_streams"
+	dmetering.RegisterMetric(dmetering.MetricDefinition{Name: gauge, Unit: dmetering.MetricUnitCount, Kind: dmetering.MetricKindGauge})
+
+	event := func(offset time.Duration, user string, streams float64) *pbmetering.Event {
+		ev := usageEvent(offset, user, "key", "eth-mainnet", "sf.firehose.v2/Blocks", 10)
+		ev.Metrics = append(ev.Metrics, &pbmetering.Metric{Key: gauge, Value: streams})
+		return ev
+	}
+
+	store := NewMemoryStore(time.Minute, nil)
+	require.NoError(t, store.Write(context.Background(), []*pbmetering.Event{
+		event(10*time.Second, "user.1", 4),
+		event(20*time.Second, "user.1", 2),
+		event(90*time.Second, "user.1", 3),
+		event(100*time.Second, "user.2", 7),
+		event(30*time.Second, "user.2", 1),
+	}))
+
+	resp, err := store.Usage(context.Background(), &pbmetering.UsageRequest{
+		GroupBy: []pbmetering.UsageDimension{pbmetering.UsageDimension_USAGE_DIMENSION_USER_ID},
+		Metrics: []string{dmetering.MetricReadBytes, gauge},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []usageRow{
+		{user: "user.1", metrics: map[string]float64{dmetering.MetricReadBytes: 30, gauge: 3}, events: 3},
+		{user: "user.2", metrics: map[string]float64{dmetering.MetricReadBytes: 20, gauge: 7}, events: 2},
+	}, usageRows(resp), "gauges keep the value of the latest bucket")
+
+	resp, err = store.Usage(context.Background(), &pbmetering.UsageRequest{
+		EndTime: timestamppb.New(usageBase.Add(time.Minute)),
+		Metrics: []string{gauge},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []usageRow{
+		{metrics: map[string]float64{gauge: 1}, events: 3},
+	}, usageRows(resp), "within a bucket the last received value is kept, ties across rollups go to the last one in dimension order")
+}
+
+func TestEncodeLabels(t *testing.T) {
+	labels := EncodeLabels(map[string]string{"module": "map_pools", "package": "uniswap", "empty": ""})
+	assert.Equal(t, EncodeLabels(map[string]string{"package": "uniswap", "module": "map_pools"}), labels, "encoding is canonical")
+	assert.Equal(t, map[string]string{"module": "map_pools", "package": "uniswap"}, labels.Map())
+	assert.Equal(t, "uniswap", labels.Get("package"))
+	assert.Equal(t, "", labels.Get("empty"))
+
+	assert.NotEqual(t, EncodeLabels(map[string]string{"a": "b=c"}), EncodeLabels(map[string]string{"a=b": "c"}))
+	assert.Equal(t, Labels(""), EncodeLabels(nil))
+	assert.Nil(t, Labels("").Map())
+}
+
+func TestMemoryStore_Usage_Labels(t *testing.T) {
+	labeled := func(labels map[string]string, readBytes float64) *pbmetering.Event {
+		ev := usageEvent(0, "user.1", "key.1", "eth-mainnet", "sf.substreams.rpc.v2/Blocks", readBytes)
+		ev.Labels = labels
+		return ev
+	}
+
+	store := NewMemoryStore(time.Minute, nil)
+	require.NoError(t, store.Write(context.Background(), []*pbmetering.Event{
+		labeled(map[string]string{"module": "map_pools", "network_segment": "1"}, 10),
+		labeled(map[string]string{"module": "map_pools", "network_segment": "2"}, 20),
+		labeled(map[string]string{"module": "map_transfers"}, 30),
+		labeled(nil, 40),
+	}))
+	assert.Len(t, store.rollups, 4, "events differing by labels only are not merged")
+
+	resp, err := store.Usage(context.Background(), &pbmetering.UsageRequest{GroupByLabels: []string{"module"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 3)
+	assert.Nil(t, resp.Records[0].Labels)
+	assert.Equal(t, float64(40), resp.Records[0].Metrics[1].Value)
+	assert.Equal(t, map[string]string{"module": "map_pools"}, resp.Records[1].Labels)
+	assert.Equal(t, float64(30), resp.Records[1].Metrics[1].Value)
+	assert.Equal(t, map[string]string{"module": "map_transfers"}, resp.Records[2].Labels)
+
+	resp, err = store.Usage(context.Background(), &pbmetering.UsageRequest{Labels: map[string]string{"module": "map_pools", "network_segment": "2"}})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Nil(t, resp.Records[0].Labels, "labels are only reported when grouped by")
+	assert.Equal(t, uint64(1), resp.Records[0].EventCount)
+	assert.Equal(t, float64(20), resp.Records[0].Metrics[1].Value)
+}
@@ -0,0 +1,187 @@
+// Package dmeteringtest holds helpers to test code emitting metering events and
+// to check that emitter plugins behave like the ones shipped with dmetering.
+package dmeteringtest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// SequenceLabel is the label holding the sequence number of the events emitted
+// by RunEmitterConformance, it's how delivered events are matched back.
+const SequenceLabel = "conformance_seq"
+
+// ShutdownTimeout bounds how long Shutdown may take in RunEmitterConformance.
+var ShutdownTimeout = 10 * time.Second
+
+// Factory creates a new emitter under test. The returned delivered function
+// reports every event the emitter handed to its destination so far. It may be
+// nil for emitters whose output cannot be observed, like the null or logger
+// ones, in which case the delivery checks are skipped.
+type Factory func(t *testing.T) (emitter dmetering.EventEmitter, delivered func() []dmetering.Event)
+
+// RunEmitterConformance runs the checks every dmetering.EventEmitter is expected
+// to pass against emitters created by factory:
+//
+//   - Emit can be called concurrently;
+//   - Emit after Shutdown is ignored without panicking;
+//   - Shutdown can be called many times, concurrently too, and returns in a timely manner;
+//   - no goroutine is left running after Shutdown;
+//   - no event is lost when a single caller emits them back to back;
+//   - events still buffered when Shutdown is called are flushed.
+func RunEmitterConformance(t *testing.T, factory Factory) {
+	t.Run("concurrent emit", func(t *testing.T) {
+		emitter, delivered := factory(t)
+
+		workers, perWorker := 8, 100
+		wg := sync.WaitGroup{}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					emitter.Emit(context.Background(), newEvent(w*perWorker+i))
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		shutdown(t, emitter)
+		assertDelivered(t, delivered, workers*perWorker)
+	})
+
+	t.Run("no loss on sequential emit", func(t *testing.T) {
+		emitter, delivered := factory(t)
+
+		for i := 0; i < 1000; i++ {
+			emitter.Emit(context.Background(), newEvent(i))
+		}
+
+		shutdown(t, emitter)
+		assertDelivered(t, delivered, 1000)
+	})
+
+	t.Run("shutdown flushes buffered events", func(t *testing.T) {
+		emitter, delivered := factory(t)
+
+		for i := 0; i < 10; i++ {
+			emitter.Emit(context.Background(), newEvent(i))
+		}
+
+		shutdown(t, emitter)
+		assertDelivered(t, delivered, 10)
+	})
+
+	t.Run("emit after shutdown", func(t *testing.T) {
+		emitter, delivered := factory(t)
+
+		emitter.Emit(context.Background(), newEvent(0))
+		shutdown(t, emitter)
+
+		require.NotPanics(t, func() {
+			emitter.Emit(context.Background(), newEvent(1))
+		})
+		assertDelivered(t, delivered, 1)
+	})
+
+	t.Run("idempotent shutdown", func(t *testing.T) {
+		emitter, _ := factory(t)
+
+		shutdown(t, emitter)
+		shutdown(t, emitter)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				emitter.Shutdown(fmt.Errorf("concurrent shutdown"))
+			}()
+		}
+		waitOrFail(t, wg.Wait, "concurrent Shutdown calls did not return")
+	})
+
+	t.Run("no goroutine leak", func(t *testing.T) {
+		ignoreCurrent := goleak.IgnoreCurrent()
+
+		emitter, _ := factory(t)
+		for i := 0; i < 10; i++ {
+			emitter.Emit(context.Background(), newEvent(i))
+		}
+		shutdown(t, emitter)
+
+		goleak.VerifyNone(t, ignoreCurrent)
+	})
+}
+
+func newEvent(seq int) dmetering.Event {
+	return dmetering.Event{
+		Endpoint:  "sf.firehose.v2/Blocks",
+		Metrics:   map[string]float64{dmetering.MetricReadBytes: float64(seq + 1)},
+		UserID:    "conformance.user",
+		ApiKeyID:  "conformance.key",
+		IpAddress: "127.0.0.1",
+		Labels:    map[string]string{SequenceLabel: strconv.Itoa(seq)},
+		Timestamp: time.Now(),
+	}
+}
+
+func shutdown(t *testing.T, emitter dmetering.EventEmitter) {
+	t.Helper()
+	waitOrFail(t, func() { emitter.Shutdown(nil) }, "Shutdown did not return")
+}
+
+func waitOrFail(t *testing.T, f func(), message string) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(ShutdownTimeout):
+		t.Fatalf("%s within %s", message, ShutdownTimeout)
+	}
+}
+
+// assertDelivered checks that exactly the events with sequence 0 to count-1 were
+// delivered, each one of them once.
+func assertDelivered(t *testing.T, delivered func() []dmetering.Event, count int) {
+	t.Helper()
+	if delivered == nil {
+		return
+	}
+
+	seen := make(map[string]int, count)
+	for _, ev := range delivered() {
+		seen[ev.Labels[SequenceLabel]]++
+	}
+
+	var missing, duplicated []int
+	for i := 0; i < count; i++ {
+		switch seen[strconv.Itoa(i)] {
+		case 0:
+			missing = append(missing, i)
+		case 1:
+		default:
+			duplicated = append(duplicated, i)
+		}
+		delete(seen, strconv.Itoa(i))
+	}
+
+	assert.Empty(t, missing, "events were lost")
+	assert.Empty(t, duplicated, "events were delivered more than once")
+	assert.Empty(t, seen, "unexpected events were delivered")
+}
@@ -0,0 +1,172 @@
+package dmeteringtest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"go.uber.org/zap"
+)
+
+// Register registers the `memory://<name>` plugin, every emitter created from
+// it records its events in the recorder returned by NamedRecorder(name), so
+// that code building its emitter from a DSN can be tested too.
+func Register() {
+	dmetering.Register("memory", func(config string, _ *zap.Logger) (dmetering.EventEmitter, error) {
+		u, err := url.Parse(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory config: %w", err)
+		}
+
+		return namedRecorder(u.Host, true), nil
+	})
+}
+
+var recorders = struct {
+	sync.Mutex
+	byName map[string]*Recorder
+}{byName: map[string]*Recorder{}}
+
+// NamedRecorder returns the recorder used by `memory://<name>` emitters, it's
+// created on first use. A recorder that was shut down is replaced by a new one
+// the next time an emitter is created for its name.
+func NamedRecorder(name string) *Recorder {
+	return namedRecorder(name, false)
+}
+
+func namedRecorder(name string, replaceShutdown bool) *Recorder {
+	recorders.Lock()
+	defer recorders.Unlock()
+
+	recorder := recorders.byName[name]
+	if recorder == nil || (replaceShutdown && recorder.IsShutdown()) {
+		recorder = NewRecorder()
+		recorders.byName[name] = recorder
+	}
+	return recorder
+}
+
+// Recorder is an emitter keeping every event it receives in memory, it's safe
+// for concurrent use. Events emitted after Shutdown are ignored.
+type Recorder struct {
+	lock     sync.Mutex
+	events   []dmetering.Event
+	changed  chan struct{}
+	shutdown bool
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		changed: make(chan struct{}),
+	}
+}
+
+// InstallAsDefault makes r the default emitter until the end of t, the previous
+// default emitter is restored by t.Cleanup.
+func (r *Recorder) InstallAsDefault(t testing.TB) *Recorder {
+	previous := dmetering.SwapDefaultEmitter(r)
+	t.Cleanup(func() {
+		dmetering.SetDefaultEmitter(previous)
+	})
+	return r
+}
+
+func (r *Recorder) Emit(_ context.Context, ev dmetering.Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.shutdown {
+		return
+	}
+
+	r.events = append(r.events, ev)
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (r *Recorder) Shutdown(error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.shutdown = true
+}
+
+func (r *Recorder) IsShutdown() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.shutdown
+}
+
+// Events returns a copy of the events recorded so far, in emission order.
+func (r *Recorder) Events() []dmetering.Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]dmetering.Event(nil), r.events...)
+}
+
+// Reset forgets the events recorded so far.
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.events = nil
+}
+
+// WaitForEvents waits until at least n events were recorded and returns them,
+// it returns the events recorded so far along with an error if that did not
+// happen within timeout.
+func (r *Recorder) WaitForEvents(n int, timeout time.Duration) ([]dmetering.Event, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		r.lock.Lock()
+		if len(r.events) >= n {
+			events := append([]dmetering.Event(nil), r.events...)
+			r.lock.Unlock()
+			return events, nil
+		}
+		changed := r.changed
+		r.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			events := r.Events()
+			return events, fmt.Errorf("recorded %d events out of %d expected after %s", len(events), n, timeout)
+		}
+	}
+}
+
+// Sum returns the total of metric over the recorded events accepted by filter,
+// a nil filter accepts every event.
+func (r *Recorder) Sum(metric string, filter func(ev dmetering.Event) bool) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	total := 0.0
+	for _, ev := range r.events {
+		if filter == nil || filter(ev) {
+			total += ev.Metrics[metric]
+		}
+	}
+	return total
+}
+
+// ByEndpoint returns the recorded events grouped by endpoint, in emission order.
+func (r *Recorder) ByEndpoint() map[string][]dmetering.Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	out := map[string][]dmetering.Event{}
+	for _, ev := range r.events {
+		out[ev.Endpoint] = append(out[ev.Endpoint], ev)
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package dmeteringtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRecorder_Conformance(t *testing.T) {
+	RunEmitterConformance(t, func(t *testing.T) (dmetering.EventEmitter, func() []dmetering.Event) {
+		recorder := NewRecorder()
+		return recorder, recorder.Events
+	})
+}
+
+func TestRecorder_Helpers(t *testing.T) {
+	recorder := NewRecorder()
+	ctx := context.Background()
+
+	recorder.Emit(ctx, dmetering.Event{Endpoint: "a", UserID: "u1", Metrics: map[string]float64{"read_bytes": 10}})
+	recorder.Emit(ctx, dmetering.Event{Endpoint: "b", UserID: "u1", Metrics: map[string]float64{"read_bytes": 5, "block_count": 1}})
+	recorder.Emit(ctx, dmetering.Event{Endpoint: "a", UserID: "u2", Metrics: map[string]float64{"read_bytes": 1}})
+
+	assert.Equal(t, 16.0, recorder.Sum("read_bytes", nil))
+	assert.Equal(t, 15.0, recorder.Sum("read_bytes", func(ev dmetering.Event) bool { return ev.UserID == "u1" }))
+	assert.Equal(t, 0.0, recorder.Sum("egress_bytes", nil))
+
+	byEndpoint := recorder.ByEndpoint()
+	require.Len(t, byEndpoint, 2)
+	assert.Equal(t, []string{"u1", "u2"}, []string{byEndpoint["a"][0].UserID, byEndpoint["a"][1].UserID})
+	assert.Len(t, byEndpoint["b"], 1)
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Events())
+}
+
+func TestRecorder_WaitForEvents(t *testing.T) {
+	recorder := NewRecorder()
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			recorder.Emit(context.Background(), dmetering.Event{Endpoint: "a"})
+		}
+	}()
+
+	events, err := recorder.WaitForEvents(3, 5*time.Second)
+	require.NoError(t, err)
+	assert.Len(t, events, 3)
+
+	events, err = recorder.WaitForEvents(4, 10*time.Millisecond)
+	assert.EqualError(t, err, "recorded 3 events out of 4 expected after 10ms")
+	assert.Len(t, events, 3)
+}
+
+func TestRecorder_InstallAsDefault(t *testing.T) {
+	previous := dmetering.GetDefaultEmitter()
+
+	var recorder *Recorder
+	t.Run("installed", func(t *testing.T) {
+		recorder = NewRecorder().InstallAsDefault(t)
+		dmetering.Emit(context.Background(), dmetering.Event{Endpoint: "a"})
+	})
+
+	assert.Len(t, recorder.Events(), 1)
+	assert.Same(t, previous, dmetering.GetDefaultEmitter())
+}
+
+func TestRecorder_MemoryDSN(t *testing.T) {
+	Register()
+
+	emitter, err := dmetering.New("memory://recorder-dsn-test", zap.NewNop())
+	require.NoError(t, err)
+
+	emitter.Emit(context.Background(), dmetering.Event{Endpoint: "a"})
+	emitter.Shutdown(nil)
+
+	recorder := NamedRecorder("recorder-dsn-test")
+	assert.Len(t, recorder.Events(), 1)
+
+	next, err := dmetering.New("memory://recorder-dsn-test", zap.NewNop())
+	require.NoError(t, err)
+	assert.NotSame(t, recorder, next, "a shut down recorder is replaced")
+	assert.Same(t, next, NamedRecorder("recorder-dsn-test"))
+}
@@ -0,0 +1,98 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streamingfast/dgrpc"
+	"github.com/streamingfast/dmetering/clock"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// emitClient is the part of pbmetering.MeteringClient used by the emitter, the
+// read side of the service is of no use to it.
+type emitClient interface {
+	Emit(ctx context.Context, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+func newMeteringClient(config *Config, logger *zap.Logger) (emitClient, CloseFunc, error) {
+	opts, err := dialOptions(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	endpoints := config.endpoints()
+
+	// With a single endpoint there is nowhere to fail over to, skipping it would only drop batches
+	breakerThreshold := config.BreakerThreshold
+	if len(endpoints) == 1 {
+		breakerThreshold = 0
+	}
+
+	pool := newClientPool(config.LoadBalancing, config.EmitTimeout, logger)
+	var closeFuncs []CloseFunc
+	closeAll := func() error {
+		var firstErr error
+		for _, closeFunc := range closeFuncs {
+			if err := closeFunc(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	for _, endpoint := range endpoints {
+		// The external client already takes care of keepalive and tracing, our own
+		// transport credentials override its TLS default when needed.
+		conn, err := dgrpc.NewExternalClient(endpoint, opts...)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("unable to create external gRPC client for %q: %w", endpoint, err)
+		}
+
+		pool.add(endpoint, pbmetering.NewMeteringClient(conn), newCircuitBreaker(breakerThreshold, config.BreakerCooldown, clock.OrReal(config.Clock)))
+		closeFuncs = append(closeFuncs, conn.Close)
+	}
+
+	return pool, closeAll, nil
+}
+
+func dialOptions(config *Config) ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+
+	switch config.Transport {
+	case TransportPlaintext, "":
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	case TransportInsecure, TransportTLS:
+		tlsConf, err := tlsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConf)))
+	default:
+		return nil, fmt.Errorf("unknown transport %q", config.Transport)
+	}
+
+	if creds := perRPCCredentials(config); creds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
+	}
+
+	var callOpts []grpc.CallOption
+	if config.Compression == CompressionGzip {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+	if config.MaxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(config.MaxSendMsgSize))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	return opts, nil
+}
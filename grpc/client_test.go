@@ -0,0 +1,214 @@
+package grpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type authServer struct {
+	pbmetering.UnimplementedMeteringServer
+
+	expectedToken string
+	received      chan *pbmetering.Events
+}
+
+func (s *authServer) Emit(ctx context.Context, events *pbmetering.Events) (*emptypb.Empty, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) != 1 || values[0] != "Bearer "+s.expectedToken {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	s.received <- events
+	return &emptypb.Empty{}, nil
+}
+
+func TestMeteringClient_MutualTLSAndToken(t *testing.T) {
+	certs := newTestCertificates(t)
+	server := &authServer{expectedToken: "s3cr3t", received: make(chan *pbmetering.Events, 1)}
+	addr := startTLSServer(t, certs, server)
+
+	tests := []struct {
+		name       string
+		config     *Config
+		expectCode codes.Code
+	}{
+		{
+			name: "valid token and client certificate",
+			config: &Config{
+				Endpoint:       addr,
+				Transport:      TransportTLS,
+				Token:          "s3cr3t",
+				CACertFile:     certs.caFile,
+				ClientCertFile: certs.clientCertFile,
+				ClientKeyFile:  certs.clientKeyFile,
+			},
+			expectCode: codes.OK,
+		},
+		{
+			name: "custom per-RPC credentials",
+			config: &Config{
+				Endpoint:          addr,
+				Transport:         TransportTLS,
+				Token:             "ignored",
+				PerRPCCredentials: NewTokenCredentials("s3cr3t", true),
+				CACertFile:        certs.caFile,
+				ClientCertFile:    certs.clientCertFile,
+				ClientKeyFile:     certs.clientKeyFile,
+			},
+			expectCode: codes.OK,
+		},
+		{
+			name: "invalid token",
+			config: &Config{
+				Endpoint:       addr,
+				Transport:      TransportTLS,
+				Token:          "wrong",
+				CACertFile:     certs.caFile,
+				ClientCertFile: certs.clientCertFile,
+				ClientKeyFile:  certs.clientKeyFile,
+			},
+			expectCode: codes.Unauthenticated,
+		},
+		{
+			name: "missing client certificate",
+			config: &Config{
+				Endpoint:   addr,
+				Transport:  TransportTLS,
+				Token:      "s3cr3t",
+				CACertFile: certs.caFile,
+			},
+			expectCode: codes.Unavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, closeFunc, err := newMeteringClient(test.config, zlog)
+			require.NoError(t, err)
+			defer closeFunc()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err = client.Emit(ctx, &pbmetering.Events{Events: []*pbmetering.Event{{Endpoint: "sf.firehose.v2/Blocks"}}})
+			assert.Equal(t, test.expectCode, status.Code(err), "unexpected error %v", err)
+
+			if test.expectCode == codes.OK {
+				received := <-server.received
+				assert.Equal(t, "sf.firehose.v2/Blocks", received.Events[0].Endpoint)
+			}
+		})
+	}
+}
+
+type testCertificates struct {
+	caFile         string
+	serverCert     tls.Certificate
+	clientCertFile string
+	clientKeyFile  string
+	pool           *x509.CertPool
+}
+
+func newTestCertificates(t *testing.T) *testCertificates {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dmetering test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			DNSNames:     []string{"localhost"},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	serverCertPEM, serverKeyPEM := issue(2, "metering collector", x509.ExtKeyUsageServerAuth)
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	require.NoError(t, err)
+
+	clientCertPEM, clientKeyPEM := issue(3, "metering emitter", x509.ExtKeyUsageClientAuth)
+
+	out := &testCertificates{
+		caFile:         filepath.Join(dir, "ca.pem"),
+		serverCert:     serverCert,
+		clientCertFile: filepath.Join(dir, "client.pem"),
+		clientKeyFile:  filepath.Join(dir, "client-key.pem"),
+		pool:           x509.NewCertPool(),
+	}
+	out.pool.AddCert(caCert)
+
+	require.NoError(t, os.WriteFile(out.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+	require.NoError(t, os.WriteFile(out.clientCertFile, clientCertPEM, 0600))
+	require.NoError(t, os.WriteFile(out.clientKeyFile, clientKeyPEM, 0600))
+
+	return out
+}
+
+func startTLSServer(t *testing.T, certs *testCertificates, server pbmetering.MeteringServer) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{certs.serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    certs.pool,
+	})))
+	pbmetering.RegisterMeteringServer(grpcServer, server)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
@@ -3,23 +3,109 @@ package grpc
 import (
 	"fmt"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/credentials"
 )
 
+// Transport defines how the connection to the metering collector is secured.
+type Transport string
+
+const (
+	// TransportPlaintext connects without TLS, the collector must listen on a plain text socket.
+	TransportPlaintext Transport = "plaintext"
+	// TransportInsecure connects over TLS but skips verification of the collector's certificate.
+	TransportInsecure Transport = "insecure"
+	// TransportTLS connects over TLS and verifies the collector's certificate against the system roots.
+	TransportTLS Transport = "tls"
+)
+
+const CompressionGzip = "gzip"
+
 type Config struct {
-	Endpoint    string
+	// Endpoint is the collector address, it's the primary one when multiple
+	// Endpoints are configured.
+	Endpoint string
+	// Endpoints lists every collector address, in priority order for failover.
+	// When empty, Endpoint is used alone.
+	Endpoints     []string
+	LoadBalancing LoadBalancing
+	// BreakerThreshold is the number of consecutive failures after which an
+	// endpoint is skipped for BreakerCooldown, zero disables the breaker. It
+	// only applies when multiple endpoints are configured.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
 	Delay       time.Duration
 	BufferSize  uint64
 	PanicOnDrop bool
 	Network     string
+
+	// ShutdownTimeout bounds how long termination waits for the remaining events
+	// to be sent, events still undelivered past it are dropped and logged. A zero
+	// value waits indefinitely.
+	ShutdownTimeout time.Duration
+
+	// EmitTimeout is the deadline applied to each Emit RPC, zero means no deadline.
+	// With multiple endpoints, an endpoint reaching it counts as a failure and the
+	// batch is sent to the next one.
+	EmitTimeout time.Duration
+	// Compression is the compressor used on Emit RPCs, empty for none or "gzip".
+	Compression string
+	// MaxSendMsgSize is the maximum size in bytes of an Emit request, zero keeps gRPC's default.
+	MaxSendMsgSize int
+	Transport      Transport
+
+	// Token is sent as a bearer token on every Emit RPC to authenticate this node
+	// against the collector.
+	Token string
+	// PerRPCCredentials, when set, is used instead of Token to authenticate Emit RPCs.
+	// It cannot be configured from the DSN, set it on the Config passed to NewEmitter.
+	PerRPCCredentials credentials.PerRPCCredentials
+
+	// CACertFile is a PEM file of the authorities trusted to sign the collector's
+	// certificate, the system roots are used when empty.
+	CACertFile string
+	// ClientCertFile and ClientKeyFile are the PEM encoded certificate and key
+	// presented to the collector for mutual TLS.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// UnknownMetrics defines how events with unregistered metric keys are handled.
+	UnknownMetrics dmetering.UnknownMetricPolicy
+
+	// Clock drives batching, shutdown deadline and circuit breakers, it cannot be
+	// set through the DSN and defaults to the wall clock. Tests use a clock.Fake.
+	Clock clock.Clock
+
+	// TracerProvider creates the spans wrapping each batch sent, it cannot be set
+	// through the DSN and defaults to the OpenTelemetry global provider.
+	TracerProvider trace.TracerProvider
+}
+
+// ParseConfig parses a `grpc://` DSN into a Config, see the README for the
+// supported options.
+func ParseConfig(dsn string) (*Config, error) {
+	return newConfig(dsn)
 }
 
 func newConfig(configURL string) (*Config, error) {
 	c := &Config{
-		Delay:       100 * time.Millisecond,
-		BufferSize:  10000,
-		PanicOnDrop: false,
+		Delay:            100 * time.Millisecond,
+		BufferSize:       10000,
+		PanicOnDrop:      false,
+		ShutdownTimeout:  10 * time.Second,
+		EmitTimeout:      5 * time.Second,
+		Transport:        TransportPlaintext,
+		LoadBalancing:    LoadBalancingFailover,
+		BreakerThreshold: 3,
+		BreakerCooldown:  30 * time.Second,
 	}
 
 	u, err := url.Parse(configURL)
@@ -27,12 +113,49 @@ func newConfig(configURL string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse urls: %w", err)
 	}
 
-	c.Endpoint = u.Host
-	if c.Endpoint == "" {
+	vals := u.Query()
+
+	if u.Host != "" {
+		c.Endpoints = append(c.Endpoints, strings.Split(u.Host, ",")...)
+	}
+	c.Endpoints = append(c.Endpoints, vals["endpoint"]...)
+	for _, endpoint := range c.Endpoints {
+		if endpoint == "" {
+			return nil, fmt.Errorf("invalid empty endpoint in %q", c.Endpoints)
+		}
+	}
+
+	if len(c.Endpoints) == 0 {
 		return nil, fmt.Errorf("endpoint not specified (as hostname)")
 	}
+	c.Endpoint = c.Endpoints[0]
+
+	loadBalancingValue := vals.Get("loadBalancing")
+	if loadBalancingValue != "" {
+		switch loadBalancing := LoadBalancing(loadBalancingValue); loadBalancing {
+		case LoadBalancingFailover, LoadBalancingRoundRobin:
+			c.LoadBalancing = loadBalancing
+		default:
+			return nil, fmt.Errorf("invalid loadBalancing value %q: expected %q or %q", loadBalancingValue, LoadBalancingFailover, LoadBalancingRoundRobin)
+		}
+	}
+
+	breakerThresholdValue := vals.Get("breakerThreshold")
+	if breakerThresholdValue != "" {
+		c.BreakerThreshold, err = strconv.Atoi(breakerThresholdValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid breakerThreshold value %q: %w", breakerThresholdValue, err)
+		}
+	}
+
+	breakerCooldownValue := vals.Get("breakerCooldown")
+	if breakerCooldownValue != "" {
+		c.BreakerCooldown, err = parseDuration(breakerCooldownValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid breakerCooldown value %q: %w", breakerCooldownValue, err)
+		}
+	}
 
-	vals := u.Query()
 	c.Network = vals.Get("network")
 	if c.Network == "" {
 		return nil, fmt.Errorf("network not specified (as query param)")
@@ -48,15 +171,100 @@ func newConfig(configURL string) (*Config, error) {
 
 	delayValue := vals.Get("delay")
 	if delayValue != "" {
-		delay, err := strconv.ParseInt(delayValue, 10, 64)
+		c.Delay, err = parseDuration(delayValue)
 		if err != nil {
 			return nil, fmt.Errorf("invalid delay value %q: %w", delayValue, err)
 		}
+	}
+
+	shutdownTimeoutValue := vals.Get("shutdownTimeout")
+	if shutdownTimeoutValue != "" {
+		c.ShutdownTimeout, err = parseDuration(shutdownTimeoutValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shutdownTimeout value %q: %w", shutdownTimeoutValue, err)
+		}
+	}
+
+	emitTimeoutValue := vals.Get("emitTimeout")
+	if emitTimeoutValue != "" {
+		c.EmitTimeout, err = parseDuration(emitTimeoutValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid emitTimeout value %q: %w", emitTimeoutValue, err)
+		}
+	}
+
+	c.Compression = vals.Get("compression")
+	if c.Compression != "" && c.Compression != CompressionGzip {
+		return nil, fmt.Errorf("invalid compression value %q: only %q is supported", c.Compression, CompressionGzip)
+	}
+
+	maxSendMsgSizeValue := vals.Get("maxSendMsgSize")
+	if maxSendMsgSizeValue != "" {
+		c.MaxSendMsgSize, err = strconv.Atoi(maxSendMsgSizeValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxSendMsgSize value %q: %w", maxSendMsgSizeValue, err)
+		}
+	}
+
+	transportValue := vals.Get("transport")
+	if transportValue != "" {
+		switch transport := Transport(transportValue); transport {
+		case TransportPlaintext, TransportInsecure, TransportTLS:
+			c.Transport = transport
+		default:
+			return nil, fmt.Errorf("invalid transport value %q: expected one of %q, %q or %q", transportValue, TransportPlaintext, TransportInsecure, TransportTLS)
+		}
+	}
+
+	switch {
+	case vals.Get("token") != "":
+		c.Token = vals.Get("token")
+	case vals.Get("tokenEnv") != "":
+		c.Token = os.Getenv(vals.Get("tokenEnv"))
+		if c.Token == "" {
+			return nil, fmt.Errorf("environment variable %q referenced by tokenEnv is empty", vals.Get("tokenEnv"))
+		}
+	case vals.Get("tokenFile") != "":
+		c.Token, err = readTokenFile(vals.Get("tokenFile"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tokenFile value %q: %w", vals.Get("tokenFile"), err)
+		}
+	}
 
-		c.Delay = time.Duration(delay) * time.Millisecond
+	c.CACertFile = vals.Get("caCert")
+	c.ClientCertFile = vals.Get("clientCert")
+	c.ClientKeyFile = vals.Get("clientKey")
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return nil, fmt.Errorf("clientCert and clientKey must be specified together")
+	}
+	if c.Transport == TransportPlaintext && (c.CACertFile != "" || c.ClientCertFile != "") {
+		return nil, fmt.Errorf("caCert, clientCert and clientKey require a TLS transport (transport=tls or transport=insecure)")
+	}
+
+	c.UnknownMetrics, err = dmetering.ParseUnknownMetricPolicy(vals.Get("unknownMetrics"))
+	if err != nil {
+		return nil, err
 	}
 
 	c.PanicOnDrop = vals.Get("panicOnDrop") == "true"
 
 	return c, nil
 }
+
+func (c *Config) endpoints() []string {
+	if len(c.Endpoints) > 0 {
+		return c.Endpoints
+	}
+
+	return []string{c.Endpoint}
+}
+
+// parseDuration parses a Go duration string like `250ms` or `5s`, a bare
+// integer is accepted as a number of milliseconds for backward compatibility.
+func parseDuration(value string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	return time.ParseDuration(value)
+}
@@ -1,9 +1,13 @@
 package grpc
 
 import (
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/streamingfast/dmetering"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -17,31 +21,202 @@ func TestConfig_new(t *testing.T) {
 		{
 			dsn: "grpc://localhost:9010?buffer=25&network=eth-mainnet",
 			expect: &Config{
-				Endpoint:   "localhost:9010",
-				Network:    "eth-mainnet",
-				Delay:      100 * time.Millisecond,
-				BufferSize: 25,
+				Endpoint:         "localhost:9010",
+				Endpoints:        []string{"localhost:9010"},
+				Network:          "eth-mainnet",
+				Delay:            100 * time.Millisecond,
+				BufferSize:       25,
+				ShutdownTimeout:  10 * time.Second,
+				EmitTimeout:      5 * time.Second,
+				Transport:        TransportPlaintext,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  30 * time.Second,
 			},
 		},
 		{
 			dsn: "grpc://localhost:9010?buffer=100000&network=eth-mainnet&panicOnDrop=true",
 			expect: &Config{
-				Endpoint:    "localhost:9010",
-				Network:     "eth-mainnet",
-				Delay:       100 * time.Millisecond,
-				BufferSize:  100000,
-				PanicOnDrop: true,
+				Endpoint:         "localhost:9010",
+				Endpoints:        []string{"localhost:9010"},
+				Network:          "eth-mainnet",
+				Delay:            100 * time.Millisecond,
+				BufferSize:       100000,
+				PanicOnDrop:      true,
+				ShutdownTimeout:  10 * time.Second,
+				EmitTimeout:      5 * time.Second,
+				Transport:        TransportPlaintext,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  30 * time.Second,
 			},
 		},
 		{
 			dsn: "grpc://localhost:9010?buffer=100000&network=eth-mainnet&delay=250",
 			expect: &Config{
-				Endpoint:   "localhost:9010",
-				Network:    "eth-mainnet",
-				Delay:      250 * time.Millisecond,
-				BufferSize: 100000,
+				Endpoint:         "localhost:9010",
+				Endpoints:        []string{"localhost:9010"},
+				Network:          "eth-mainnet",
+				Delay:            250 * time.Millisecond,
+				BufferSize:       100000,
+				ShutdownTimeout:  10 * time.Second,
+				EmitTimeout:      5 * time.Second,
+				Transport:        TransportPlaintext,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  30 * time.Second,
 			},
 		},
+		{
+			dsn: "grpc://localhost:9010?network=eth-mainnet&shutdownTimeout=2500",
+			expect: &Config{
+				Endpoint:         "localhost:9010",
+				Endpoints:        []string{"localhost:9010"},
+				Network:          "eth-mainnet",
+				Delay:            100 * time.Millisecond,
+				BufferSize:       10000,
+				ShutdownTimeout:  2500 * time.Millisecond,
+				EmitTimeout:      5 * time.Second,
+				Transport:        TransportPlaintext,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  30 * time.Second,
+			},
+		},
+		{
+			dsn: "grpc://metering.example.com:443?network=eth-mainnet&emitTimeout=1500&compression=gzip&maxSendMsgSize=8388608&transport=tls",
+			expect: &Config{
+				Endpoint:         "metering.example.com:443",
+				Endpoints:        []string{"metering.example.com:443"},
+				Network:          "eth-mainnet",
+				Delay:            100 * time.Millisecond,
+				BufferSize:       10000,
+				ShutdownTimeout:  10 * time.Second,
+				EmitTimeout:      1500 * time.Millisecond,
+				Compression:      "gzip",
+				MaxSendMsgSize:   8388608,
+				Transport:        TransportTLS,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  30 * time.Second,
+			},
+		},
+		{
+			dsn: "grpc://localhost:9010?network=eth-mainnet&transport=insecure&emitTimeout=0",
+			expect: &Config{
+				Endpoint:         "localhost:9010",
+				Endpoints:        []string{"localhost:9010"},
+				Network:          "eth-mainnet",
+				Delay:            100 * time.Millisecond,
+				BufferSize:       10000,
+				ShutdownTimeout:  10 * time.Second,
+				Transport:        TransportInsecure,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  30 * time.Second,
+			},
+		},
+		{
+			dsn: "grpc://metering.example.com:443?network=eth-mainnet&transport=tls&token=s3cr3t&caCert=/etc/ca.pem&clientCert=/etc/node.pem&clientKey=/etc/node-key.pem",
+			expect: &Config{
+				Endpoint:         "metering.example.com:443",
+				Endpoints:        []string{"metering.example.com:443"},
+				Network:          "eth-mainnet",
+				Delay:            100 * time.Millisecond,
+				BufferSize:       10000,
+				ShutdownTimeout:  10 * time.Second,
+				EmitTimeout:      5 * time.Second,
+				Transport:        TransportTLS,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  30 * time.Second,
+				Token:            "s3cr3t",
+				CACertFile:       "/etc/ca.pem",
+				ClientCertFile:   "/etc/node.pem",
+				ClientKeyFile:    "/etc/node-key.pem",
+			},
+		},
+		{
+			dsn: "grpc://collector-a:9010,collector-b:9010?network=eth-mainnet&endpoint=collector-c:9010&loadBalancing=round_robin&breakerThreshold=5&breakerCooldown=10000",
+			expect: &Config{
+				Endpoint:         "collector-a:9010",
+				Endpoints:        []string{"collector-a:9010", "collector-b:9010", "collector-c:9010"},
+				Network:          "eth-mainnet",
+				Delay:            100 * time.Millisecond,
+				BufferSize:       10000,
+				ShutdownTimeout:  10 * time.Second,
+				EmitTimeout:      5 * time.Second,
+				Transport:        TransportPlaintext,
+				LoadBalancing:    LoadBalancingRoundRobin,
+				BreakerThreshold: 5,
+				BreakerCooldown:  10 * time.Second,
+			},
+		},
+		{
+			dsn:         "grpc://collector-a:9010?network=eth-mainnet&loadBalancing=random",
+			expectError: true,
+		},
+		{
+			dsn:         "grpc://localhost:9010?network=eth-mainnet&clientCert=/etc/node.pem",
+			expectError: true,
+		},
+		{
+			dsn:         "grpc://localhost:9010?network=eth-mainnet&clientCert=/etc/node.pem&clientKey=/etc/node-key.pem",
+			expectError: true,
+		},
+		{
+			dsn:         "grpc://localhost:9010?network=eth-mainnet&tokenEnv=DMETERING_TEST_UNSET_TOKEN",
+			expectError: true,
+		},
+		{
+			dsn:         "grpc://localhost:9010?network=eth-mainnet&compression=snappy",
+			expectError: true,
+		},
+		{
+			dsn:         "grpc://localhost:9010?network=eth-mainnet&transport=ssl",
+			expectError: true,
+		},
+		{
+			dsn: "grpc://localhost:9010?network=eth-mainnet&delay=250ms&shutdownTimeout=1m&emitTimeout=5s&breakerCooldown=1m30s",
+			expect: &Config{
+				Endpoint:         "localhost:9010",
+				Endpoints:        []string{"localhost:9010"},
+				Network:          "eth-mainnet",
+				Delay:            250 * time.Millisecond,
+				BufferSize:       10000,
+				ShutdownTimeout:  time.Minute,
+				EmitTimeout:      5 * time.Second,
+				Transport:        TransportPlaintext,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  90 * time.Second,
+			},
+		},
+		{
+			dsn: "grpc://localhost:9010?network=eth-mainnet&unknownMetrics=reject",
+			expect: &Config{
+				Endpoint:         "localhost:9010",
+				Endpoints:        []string{"localhost:9010"},
+				Network:          "eth-mainnet",
+				Delay:            100 * time.Millisecond,
+				BufferSize:       10000,
+				ShutdownTimeout:  10 * time.Second,
+				EmitTimeout:      5 * time.Second,
+				Transport:        TransportPlaintext,
+				LoadBalancing:    LoadBalancingFailover,
+				BreakerThreshold: 3,
+				BreakerCooldown:  30 * time.Second,
+				UnknownMetrics:   dmetering.UnknownMetricReject,
+			},
+		},
+		{
+			dsn:         "grpc://localhost:9010?network=eth-mainnet&unknownMetrics=ignore",
+			expectError: true,
+		},
+		{
+			dsn:         "grpc://localhost:9010?network=eth-mainnet&delay=250mss",
+			expectError: true,
+		},
 		{
 			dsn:         "grpc:localhost9010?buffer=100000&network=eth-mainnet&panicOnDrop=true",
 			expectError: true,
@@ -60,3 +235,72 @@ func TestConfig_new(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_tokenSources(t *testing.T) {
+	t.Setenv("DMETERING_TEST_TOKEN", "from-env")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("from-file\n"), 0600))
+
+	c, err := newConfig("grpc://localhost:9010?network=eth-mainnet&tokenEnv=DMETERING_TEST_TOKEN")
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", c.Token)
+
+	c, err = newConfig("grpc://localhost:9010?network=eth-mainnet&tokenFile=" + tokenFile)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", c.Token)
+}
+
+func TestConfig_environmentExpansion(t *testing.T) {
+	Register()
+
+	t.Setenv("DMETERING_TEST_COLLECTOR", "collector.internal:9010")
+	t.Setenv("DMETERING_TEST_TOKEN", "s3cr3t")
+
+	e, err := dmetering.New("grpc://${DMETERING_TEST_COLLECTOR}?network=eth-$mainnet&token=${DMETERING_TEST_TOKEN}", zlog)
+	require.NoError(t, err)
+	defer e.Shutdown(nil)
+
+	config := e.(*emitter).config
+	assert.Equal(t, "collector.internal:9010", config.Endpoint)
+	assert.Equal(t, "s3cr3t", config.Token)
+	assert.Equal(t, "eth-$mainnet", config.Network, "unbraced references are left untouched")
+
+	_, err = dmetering.New("grpc://localhost:9010?network=eth-mainnet&token=${DMETERING_TEST_UNSET_TOKEN}", zlog)
+	assert.EqualError(t, err, `environment variables ["DMETERING_TEST_UNSET_TOKEN"] referenced in config are not set`)
+}
+
+func TestConfig_environmentExpansionEscaping(t *testing.T) {
+	Register()
+
+	t.Setenv("DMETERING_TEST_TOKEN", "ab+c/d&x=1")
+
+	e, err := dmetering.New("grpc://localhost:9010?network=eth-mainnet&token=${DMETERING_TEST_TOKEN}", zlog)
+	require.NoError(t, err)
+	defer e.Shutdown(nil)
+	assert.Equal(t, "ab+c/d&x=1", e.(*emitter).config.Token, "expanded values must not alter the query string")
+
+	_, err = dmetering.New("grpc://localhost:9010?network=eth-mainnet&token=${DMETERING_TEST_TOKEN}&buffer=x", zlog)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "ab+c/d&x=1")
+	assert.NotContains(t, err.Error(), url.QueryEscape("ab+c/d&x=1"))
+	assert.Equal(t, `invalid grpc config: invalid buffer value "x": strconv.ParseUint: parsing "x": invalid syntax`, err.Error())
+}
+
+func TestConfig_environmentExpansionShortValues(t *testing.T) {
+	Register()
+
+	t.Setenv("DMETERING_TEST_NET", "e")
+	t.Setenv("DMETERING_TEST_BUF", "10")
+
+	_, err := dmetering.New("grpc://localhost:9010?network=${DMETERING_TEST_NET}&buffer=abc", zlog)
+	assert.EqualError(t, err, `invalid grpc config: invalid buffer value "abc": strconv.ParseUint: parsing "abc": invalid syntax`, "short values must not alter the rest of the message")
+
+	_, err = dmetering.New("grpc://localhost:9010?network=eth-mainnet&delay=${DMETERING_TEST_BUF}0x", zlog)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid delay value "${DMETERING_TEST_BUF}0x"`)
+	assert.NotContains(t, err.Error(), `"100x"`)
+
+	_, err = dmetering.New("grpc://localhost:9010?network=eth-mainnet&buffer=${DMETERING_TEST_NET}", zlog)
+	assert.EqualError(t, err, `invalid grpc config: invalid buffer value "${DMETERING_TEST_NET}": strconv.ParseUint: parsing "${DMETERING_TEST_NET}": invalid syntax`)
+}
@@ -0,0 +1,82 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// NewTokenCredentials returns per-RPC credentials sending token as an
+// `authorization: Bearer <token>` header on every call. When requireTLS is
+// true, gRPC refuses to send the token over a plain text connection.
+func NewTokenCredentials(token string, requireTLS bool) credentials.PerRPCCredentials {
+	return &tokenCredentials{token: token, requireTLS: requireTLS}
+}
+
+type tokenCredentials struct {
+	token      string
+	requireTLS bool
+}
+
+func (c *tokenCredentials) GetRequestMetadata(_ context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c *tokenCredentials) RequireTransportSecurity() bool {
+	return c.requireTLS
+}
+
+func perRPCCredentials(config *Config) credentials.PerRPCCredentials {
+	if config.PerRPCCredentials != nil {
+		return config.PerRPCCredentials
+	}
+
+	if config.Token != "" {
+		return NewTokenCredentials(config.Token, config.Transport != TransportPlaintext)
+	}
+
+	return nil
+}
+
+func tlsConfig(config *Config) (*tls.Config, error) {
+	out := &tls.Config{
+		InsecureSkipVerify: config.Transport == TransportInsecure,
+	}
+
+	if config.CACertFile != "" {
+		pem, err := os.ReadFile(config.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificate: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in CA certificate file %q", config.CACertFile)
+		}
+		out.RootCAs = pool
+	}
+
+	if config.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		out.Certificates = []tls.Certificate{cert}
+	}
+
+	return out, nil
+}
+
+func readTokenFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
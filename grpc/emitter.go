@@ -3,23 +3,26 @@ package grpc
 import (
 	"context"
 	"fmt"
-	"time"
-
-	"github.com/streamingfast/dmetrics"
+	"sync"
 
 	"github.com/streamingfast/shutter"
 
-	"github.com/streamingfast/dgrpc"
 	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
 	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/status"
 )
 
 func Register() {
 	dmetering.Register("grpc", func(config string, logger *zap.Logger) (dmetering.EventEmitter, error) {
 		c, err := newConfig(config)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse config string %s: %w", config, err)
+			return nil, fmt.Errorf("invalid grpc config: %w", err)
 		}
 		return new(c, logger)
 	})
@@ -31,17 +34,36 @@ type emitter struct {
 	*shutter.Shutter
 	config *Config
 
-	activeBatch     []*pbmetering.Event
-	buffer          chan dmetering.Event
-	client          pbmetering.MeteringClient
+	activeBatch []*pbmetering.Event
+	buffer      chan dmetering.Event
+	// bufferLock guards buffer against being closed while Emit sends to it.
+	bufferLock   sync.RWMutex
+	bufferClosed bool
+
+	client          emitClient
 	clientCloseFunc CloseFunc
 	done            chan bool
+	clock           clock.Clock
+	stats           emitterStats
+	tracer          trace.Tracer
+
+	// ctx bounds every call made to the metering client, it is canceled once the
+	// shutdown deadline expires so that termination is never held by the collector.
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	logger *zap.Logger
 }
 
+// NewEmitter creates a grpc emitter from an already built config, it's the
+// entry point to use when options that cannot be expressed in a DSN, like
+// custom per-RPC credentials, are needed.
+func NewEmitter(config *Config, logger *zap.Logger) (dmetering.EventEmitter, error) {
+	return new(config, logger)
+}
+
 func new(config *Config, logger *zap.Logger) (dmetering.EventEmitter, error) {
-	client, closeFunc, err := newMeteringClient(config.Endpoint)
+	client, closeFunc, err := newMeteringClient(config, logger.Named("metrics.emitter"))
 	if err != nil {
 		return nil, fmt.Errorf("unable to create external gRPC client %w", err)
 	}
@@ -51,10 +73,12 @@ func new(config *Config, logger *zap.Logger) (dmetering.EventEmitter, error) {
 
 func newWithClient(
 	config *Config,
-	client pbmetering.MeteringClient,
+	client emitClient,
 	closeFunc CloseFunc,
 	logger *zap.Logger,
 ) (dmetering.EventEmitter, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	e := &emitter{
 		Shutter:         shutter.New(),
 		config:          config,
@@ -63,68 +87,147 @@ func newWithClient(
 		buffer:          make(chan dmetering.Event, config.BufferSize),
 		activeBatch:     []*pbmetering.Event{},
 		done:            make(chan bool, 1),
+		clock:           clock.OrReal(config.Clock),
+		tracer:          tracerProvider(config).Tracer("github.com/streamingfast/dmetering/grpc"),
+		ctx:             ctx,
+		cancel:          cancel,
 		logger:          logger.Named("metrics.emitter"),
 	}
 
 	go e.launch()
 
-	dmetrics.Register(MetricSet)
+	registerMetrics()
 
 	e.OnTerminating(func(err error) {
-		e.logger.Info("received shutdown signal, waiting for launch loop to end", zap.Error(err))
+		e.logger.Info("received shutdown signal, waiting for launch loop to end", zap.Error(err), zap.Duration("shutdown_timeout", e.config.ShutdownTimeout))
+		if e.config.ShutdownTimeout > 0 {
+			deadline := e.clock.AfterFunc(e.config.ShutdownTimeout, e.cancel)
+			defer deadline.Stop()
+		}
+		defer e.cancel()
+
 		<-e.done
 		e.flushAndCloseEvent()
 		e.clientCloseFunc()
-
 	})
 	return e, nil
 }
 
+func tracerProvider(config *Config) trace.TracerProvider {
+	if config.TracerProvider != nil {
+		return config.TracerProvider
+	}
+	return otel.GetTracerProvider()
+}
+
 func (e *emitter) launch() {
-	ticker := time.NewTicker(e.config.Delay)
+	ticker := e.clock.NewTicker(e.config.Delay)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-e.Terminating():
 			e.done <- true
-		case <-ticker.C:
+			return
+		case <-ticker.C():
+			// Events queued before the tick belong to this batch, whichever case select picked first
+			e.drainBuffer()
 			e.logger.Debug("emitting events after ticker delay", zap.Int("count", len(e.activeBatch)))
-			e.emit(e.activeBatch)
-			e.activeBatch = []*pbmetering.Event{}
+			e.emit(e.takeBatch())
+			QueueDepthGauge.SetInt(e.Stats().Queued, e.config.Network)
+		case ev := <-e.buffer:
+			e.addToBatch(ev)
+		}
+	}
+}
+
+func (e *emitter) addToBatch(ev dmetering.Event) {
+	e.activeBatch = append(e.activeBatch, ev.ToProto(e.config.Network))
+	e.stats.batched.Store(int64(len(e.activeBatch)))
+}
+
+// takeBatch returns the active batch and starts a new one, the events taken
+// are not counted as queued anymore.
+func (e *emitter) takeBatch() []*pbmetering.Event {
+	batch := e.activeBatch
+	e.activeBatch = []*pbmetering.Event{}
+	e.stats.batched.Store(0)
+	return batch
+}
+
+func (e *emitter) drainBuffer() {
+	for {
+		select {
 		case ev := <-e.buffer:
-			e.activeBatch = append(e.activeBatch, ev.ToProto(e.config.Network))
+			e.addToBatch(ev)
+		default:
+			return
 		}
 	}
 }
 
 func (e *emitter) flushAndCloseEvent() {
+	e.bufferLock.Lock()
+	e.bufferClosed = true
 	close(e.buffer)
+	e.bufferLock.Unlock()
 
-	t0 := time.Now()
+	t0 := e.clock.Now()
 	e.logger.Info("waiting for event flush to complete", zap.Int("count", len(e.buffer)))
 	defer func() {
-		e.logger.Info("event flushed", zap.Duration("elapsed", time.Since(t0)))
+		e.logger.Info("event flushed", zap.Duration("elapsed", e.clock.Now().Sub(t0)))
 	}()
 
-	for {
-		ev, ok := <-e.buffer
-		protoEv := ev.ToProto(e.config.Network)
-		if !ok {
-			e.logger.Info("sending last events", zap.Int("count", len(e.activeBatch)))
-			e.emit(e.activeBatch)
-			return
-		}
-		e.activeBatch = append(e.activeBatch, protoEv)
+	for ev := range e.buffer {
+		e.addToBatch(ev)
 	}
+
+	batch := e.takeBatch()
+	e.logger.Info("sending last events", zap.Int("count", len(batch)))
+	if err := e.emit(batch); err != nil {
+		e.dropUndelivered(batch, err)
+	}
+	QueueDepthGauge.SetInt(0, e.config.Network)
 }
 
-func (e *emitter) Emit(_ context.Context, ev dmetering.Event) {
+// dropUndelivered accounts for events that could not be sent to the collector
+// before the emitter terminated. They are logged with their count per endpoint
+// so that the loss is visible and can be reconciled afterward.
+func (e *emitter) dropUndelivered(events []*pbmetering.Event, err error) {
+	countByEndpoint := map[string]int{}
+	for _, ev := range events {
+		countByEndpoint[ev.Endpoint]++
+	}
+
+	DroppedEventCounter.AddInt(len(events))
+	e.logger.Warn("unable to deliver remaining events before shutdown, dropping them",
+		zap.Int("count", len(events)),
+		zap.Any("count_by_endpoint", countByEndpoint),
+		zap.String("network", e.config.Network),
+		zap.Error(err),
+	)
+}
+
+func (e *emitter) Emit(ctx context.Context, ev dmetering.Event) {
+	ev = ev.WithTrace(ctx)
+
 	if ev.Endpoint == "" {
 		e.logger.Warn("events must contain endpoint, dropping event", zap.Object("event", ev))
+		e.stats.dropped.Add(1)
 		return
 	}
 
-	if e.IsTerminating() {
-		e.logger.Warn("emitter is shutting down cannot track event", zap.Object("event", ev))
+	if !e.config.UnknownMetrics.Check(ev, e.logger) {
+		e.stats.dropped.Add(1)
+		return
+	}
+
+	e.bufferLock.RLock()
+	defer e.bufferLock.RUnlock()
+
+	if e.bufferClosed {
+		e.logger.Warn("emitter is shut down cannot track event", zap.Object("event", ev))
+		e.stats.dropped.Add(1)
 		return
 	}
 
@@ -135,27 +238,47 @@ func (e *emitter) Emit(_ context.Context, ev dmetering.Event) {
 			panic(fmt.Errorf("failed to queue metric channel is full"))
 		}
 		DroppedEventCounter.Inc()
+		e.stats.dropped.Add(1)
 	}
 }
 
-func (e *emitter) emit(events []*pbmetering.Event) {
+func (e *emitter) emit(events []*pbmetering.Event) error {
 	if len(events) == 0 {
-		return
+		return nil
 	}
 	e.logger.Debug("tracking events", zap.Int("count", len(events)))
-	if _, err := e.client.Emit(context.Background(), &pbmetering.Events{Events: events}); err != nil {
-		MeteringGRPCErrCounter.Inc()
-		e.logger.Warn("failed to emit event", zap.Error(err))
-	}
-	return
-}
 
-func newMeteringClient(endpoint string) (pbmetering.MeteringClient, CloseFunc, error) {
-	conn, err := dgrpc.NewInternalNoWaitClient(endpoint)
+	// EmitTimeout is applied by the client pool to each endpoint attempt
+	ctx, span := e.tracer.Start(e.ctx, "dmetering.grpc.emit",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.Int("metering.batch_size", len(events)),
+			attribute.String("metering.network", e.config.Network),
+		),
+	)
+	defer span.End()
+
+	BatchSizeHistogram.WithLabelValues(e.config.Network).Observe(float64(len(events)))
+
+	e.stats.inFlight.Add(1)
+	start := e.clock.Now()
+	_, err := e.client.Emit(ctx, &pbmetering.Events{Events: events})
+	EmitLatencyHistogram.ObserveDuration(e.clock.Now().Sub(start), e.config.Network)
+	e.stats.inFlight.Add(-1)
+
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to create external gRPC client: %w", err)
+		MeteringGRPCErrCounter.Inc()
+		EmitErrorCounter.Inc(status.Code(err).String(), e.config.Network)
+		span.SetAttributes(attribute.String("metering.outcome", "error"))
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+		e.stats.sendFailed(len(events), err, e.clock.Now())
+		e.logger.Warn("failed to emit event", zap.Error(err))
+		return err
 	}
 
-	client := pbmetering.NewMeteringClient(conn)
-	return client, conn.Close, nil
+	EventsSentCounter.AddInt(len(events), e.config.Network)
+	span.SetAttributes(attribute.String("metering.outcome", "success"))
+	e.stats.sent(len(events), e.clock.Now())
+	return nil
 }
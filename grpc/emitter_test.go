@@ -2,14 +2,23 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	"github.com/streamingfast/dmetering/dmeteringtest"
 	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
 	"github.com/streamingfast/logging"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/emptypb"
@@ -84,10 +93,200 @@ func TestAuthenticatorPlugin_ContinuousAuthenticate(t *testing.T) {
 				plugin.Emit(ctx, newEvent("read_bytes", float64(i+1)))
 			}
 
-			time.Sleep(1 * time.Second)
 			plugin.Shutdown(nil)
 			assert.Equal(t, test.eventCount, eventClient.eventCount)
 			assert.Equal(t, test.expectTotalBytes, eventClient.totalBytes)
 		})
 	}
 }
+
+type blockingClient struct {
+	calls  int
+	called chan struct{}
+}
+
+func (c *blockingClient) Emit(ctx context.Context, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.calls++
+	c.called <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestEmitter_ShutdownTimeout(t *testing.T) {
+	clock := clock.NewFake(time.Unix(0, 0))
+	eventClient := &blockingClient{called: make(chan struct{}, 1)}
+
+	config := &Config{
+		Endpoint:        "localhost:9000",
+		Delay:           time.Hour,
+		BufferSize:      100,
+		Network:         "eth-testnet",
+		ShutdownTimeout: 10 * time.Second,
+		Clock:           clock,
+	}
+	plugin, err := newWithClient(config, eventClient, func() error { return nil }, zlog)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		plugin.Emit(context.Background(), newEvent("read_bytes", float64(i+1)))
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		plugin.Shutdown(nil)
+		close(shutdownDone)
+	}()
+
+	// The final flush is blocked on the collector, only the deadline can release it
+	<-eventClient.called
+	select {
+	case <-shutdownDone:
+		t.Fatal("shutdown completed before its deadline")
+	default:
+	}
+
+	clock.Advance(config.ShutdownTimeout)
+	<-shutdownDone
+
+	assert.Equal(t, 1, eventClient.calls)
+}
+
+type batchClient struct {
+	batches chan []*pbmetering.Event
+}
+
+func (c *batchClient) Emit(ctx context.Context, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.batches <- in.Events
+	return &emptypb.Empty{}, nil
+}
+
+func TestEmitter_BatchesOnTick(t *testing.T) {
+	clock := clock.NewFake(time.Unix(0, 0))
+	eventClient := &batchClient{batches: make(chan []*pbmetering.Event, 10)}
+
+	config := &Config{
+		Endpoint:   "localhost:9000",
+		Delay:      time.Second,
+		BufferSize: 100,
+		Network:    "eth-testnet",
+		Clock:      clock,
+	}
+	plugin, err := newWithClient(config, eventClient, func() error { return nil }, zlog)
+	require.NoError(t, err)
+	clock.BlockUntil(1)
+
+	for i := 0; i < 3; i++ {
+		plugin.Emit(context.Background(), newEvent("read_bytes", float64(i+1)))
+	}
+
+	clock.Advance(999 * time.Millisecond)
+	assert.Len(t, eventClient.batches, 0, "no batch should be sent before the delay elapsed")
+
+	clock.Advance(time.Millisecond)
+	assert.Len(t, <-eventClient.batches, 3)
+
+	for i := 0; i < 2; i++ {
+		plugin.Emit(context.Background(), newEvent("read_bytes", float64(i+1)))
+	}
+	clock.Advance(time.Second)
+	assert.Len(t, <-eventClient.batches, 2)
+
+	plugin.Emit(context.Background(), newEvent("read_bytes", 1))
+	plugin.Shutdown(nil)
+	assert.Len(t, <-eventClient.batches, 1, "shutdown should flush the pending batch")
+	assert.Len(t, eventClient.batches, 0)
+}
+
+type recordingClient struct {
+	lock   sync.Mutex
+	events []dmetering.Event
+}
+
+func (c *recordingClient) Emit(ctx context.Context, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, pbev := range in.Events {
+		ev, _ := dmetering.EventFromProto(pbev)
+		c.events = append(c.events, ev)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (c *recordingClient) delivered() []dmetering.Event {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]dmetering.Event(nil), c.events...)
+}
+
+func TestEmitter_Conformance(t *testing.T) {
+	dmeteringtest.RunEmitterConformance(t, func(t *testing.T) (dmetering.EventEmitter, func() []dmetering.Event) {
+		client := &recordingClient{}
+		config := &Config{
+			Endpoint:        "localhost:9000",
+			Delay:           10 * time.Millisecond,
+			BufferSize:      10000,
+			Network:         "eth-testnet",
+			ShutdownTimeout: 5 * time.Second,
+		}
+
+		plugin, err := newWithClient(config, client, func() error { return nil }, zlog)
+		require.NoError(t, err)
+
+		return plugin, client.delivered
+	})
+}
+
+func TestEmitter_Spans(t *testing.T) {
+	clock := clock.NewFake(time.Unix(0, 0))
+	client := &controlledClient{calls: make(chan *pbmetering.Events), results: make(chan error)}
+	recorder := tracetest.NewSpanRecorder()
+
+	config := &Config{
+		Endpoint:       "localhost:9000",
+		Delay:          time.Second,
+		BufferSize:     100,
+		Network:        "eth-testnet",
+		Clock:          clock,
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	}
+	plugin, err := newWithClient(config, client, func() error { return nil }, zlog)
+	require.NoError(t, err)
+	clock.BlockUntil(1)
+
+	plugin.Emit(context.Background(), newEvent("read_bytes", 1))
+	plugin.Emit(context.Background(), newEvent("read_bytes", 2))
+	clock.Advance(time.Second)
+	<-client.calls
+	client.results <- errors.New("collector unavailable")
+
+	plugin.Emit(context.Background(), newEvent("read_bytes", 3))
+	shutdownDone := make(chan struct{})
+	go func() {
+		plugin.Shutdown(nil)
+		close(shutdownDone)
+	}()
+	<-client.calls
+	client.results <- nil
+	<-shutdownDone
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "dmetering.grpc.emit", spans[0].Name())
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	assert.Equal(t, otelcodes.Error, spans[0].Status().Code)
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attribute.Int("metering.batch_size", 2),
+		attribute.String("metering.network", "eth-testnet"),
+		attribute.String("metering.outcome", "error"),
+	}, spans[0].Attributes())
+
+	assert.Equal(t, otelcodes.Unset, spans[1].Status().Code)
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attribute.Int("metering.batch_size", 1),
+		attribute.String("metering.network", "eth-testnet"),
+		attribute.String("metering.outcome", "success"),
+	}, spans[1].Attributes())
+}
@@ -1,7 +1,36 @@
 package grpc
 
-import "github.com/streamingfast/dmetrics"
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streamingfast/dmetrics"
+)
 
 var MetricSet = dmetrics.NewSet()
 var DroppedEventCounter = MetricSet.NewCounter("dropped_event_counter", "Counter of drop metering events")
 var MeteringGRPCErrCounter = MetricSet.NewCounter("metering_grpc_err_counter", "Counter of GRPC errors received")
+
+var QueueDepthGauge = MetricSet.NewGaugeVec("metering_queue_depth", []string{"network"}, "Number of metering events queued and not sent yet")
+var EventsSentCounter = MetricSet.NewCounterVec("metering_events_sent_counter", []string{"network"}, "Counter of metering events sent to the collector")
+var EmitErrorCounter = MetricSet.NewCounterVec("metering_emit_error_counter", []string{"code", "network"}, "Counter of failed Emit RPCs by gRPC status code")
+var EmitLatencyHistogram = MetricSet.NewHistogramVec("metering_emit_duration_seconds", []string{"network"}, "Latency of Emit RPCs")
+
+// BatchSizeHistogram is not part of MetricSet, dmetrics histograms only have the
+// default buckets which are meant for latencies.
+var BatchSizeHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "metering_batch_size",
+	Help:    "Number of metering events per batch sent to the collector",
+	Buckets: prometheus.ExponentialBuckets(1, 4, 9),
+}, []string{"network"})
+
+var registerMetricsOnce sync.Once
+
+// registerMetrics registers the metrics of this package, it's safe to call for
+// every emitter created.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		dmetrics.Register(MetricSet)
+		dmetrics.PrometheusRegister(BatchSizeHistogram)
+	})
+}
@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/streamingfast/dmetering/clock"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEmitter_PrometheusMetrics(t *testing.T) {
+	network := "metrics-testnet"
+	clock := clock.NewFake(time.Unix(0, 0))
+	client := &controlledClient{calls: make(chan *pbmetering.Events), results: make(chan error)}
+
+	config := &Config{
+		Endpoint:   "localhost:9000",
+		Delay:      time.Second,
+		BufferSize: 100,
+		Network:    network,
+		Clock:      clock,
+	}
+	sentBefore := testutil.ToFloat64(EventsSentCounter.Native().WithLabelValues(network))
+	errorsBefore := testutil.ToFloat64(EmitErrorCounter.Native().WithLabelValues(codes.Unavailable.String(), network))
+	latencyBefore := histogramSample(t, EmitLatencyHistogram.Native(), network)
+	batchBefore := histogramSample(t, BatchSizeHistogram, network)
+
+	plugin, err := newWithClient(config, client, func() error { return nil }, zlog)
+	require.NoError(t, err)
+	clock.BlockUntil(1)
+
+	for i := 0; i < 3; i++ {
+		plugin.Emit(context.Background(), newEvent("read_bytes", 1))
+	}
+	clock.Advance(time.Second)
+	<-client.calls
+	clock.Advance(250 * time.Millisecond)
+	client.results <- status.Error(codes.Unavailable, "collector down")
+
+	plugin.Emit(context.Background(), newEvent("read_bytes", 1))
+	clock.Advance(750 * time.Millisecond)
+	<-client.calls
+	client.results <- nil
+	plugin.Shutdown(nil)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(EventsSentCounter.Native().WithLabelValues(network))-sentBefore)
+	assert.Equal(t, 1.0, testutil.ToFloat64(EmitErrorCounter.Native().WithLabelValues(codes.Unavailable.String(), network))-errorsBefore)
+	assert.Equal(t, 0.0, testutil.ToFloat64(QueueDepthGauge.Native().WithLabelValues(network)))
+
+	latency := histogramSample(t, EmitLatencyHistogram.Native(), network)
+	assert.Equal(t, uint64(2), latency.count-latencyBefore.count)
+	assert.Equal(t, 1.0, latency.sum-latencyBefore.sum, "latencies are measured with the emitter clock")
+
+	batch := histogramSample(t, BatchSizeHistogram, network)
+	assert.Equal(t, uint64(2), batch.count-batchBefore.count)
+	assert.Equal(t, 4.0, batch.sum-batchBefore.sum)
+}
+
+type sample struct {
+	count uint64
+	sum   float64
+}
+
+func histogramSample(t *testing.T, vec *prometheus.HistogramVec, network string) sample {
+	t.Helper()
+
+	metric := &dto.Metric{}
+	require.NoError(t, vec.WithLabelValues(network).(prometheus.Histogram).Write(metric))
+	return sample{count: metric.Histogram.GetSampleCount(), sum: metric.Histogram.GetSampleSum()}
+}
+
+func TestRegisterMetrics_Once(t *testing.T) {
+	assert.NotPanics(t, func() {
+		registerMetrics()
+		registerMetrics()
+	})
+}
@@ -0,0 +1,178 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/streamingfast/dmetering/clock"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// LoadBalancing defines how batches are spread across multiple collector endpoints.
+type LoadBalancing string
+
+const (
+	// LoadBalancingFailover sends every batch to the first healthy endpoint in
+	// configuration order, the first endpoint being the primary.
+	LoadBalancingFailover LoadBalancing = "failover"
+	// LoadBalancingRoundRobin rotates batches across all healthy endpoints.
+	LoadBalancingRoundRobin LoadBalancing = "round_robin"
+)
+
+// clientPool is an emitClient dispatching each Emit call to one of
+// several collector endpoints. Each endpoint has its own circuit breaker so that
+// an unreachable collector is skipped until its cooldown expires instead of
+// stalling every batch. Each attempt gets its own attemptTimeout so that a hung
+// endpoint leaves time to try the next one.
+type clientPool struct {
+	strategy       LoadBalancing
+	attemptTimeout time.Duration
+	members        []*poolMember
+	next           uint64
+
+	logger *zap.Logger
+}
+
+type poolMember struct {
+	endpoint string
+	client   emitClient
+	breaker  *circuitBreaker
+}
+
+func newClientPool(strategy LoadBalancing, attemptTimeout time.Duration, logger *zap.Logger) *clientPool {
+	return &clientPool{
+		strategy:       strategy,
+		attemptTimeout: attemptTimeout,
+		logger:         logger,
+	}
+}
+
+func (p *clientPool) add(endpoint string, client emitClient, breaker *circuitBreaker) {
+	p.members = append(p.members, &poolMember{endpoint: endpoint, client: client, breaker: breaker})
+}
+
+func (p *clientPool) Emit(ctx context.Context, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	var lastErr error
+	attempted := 0
+
+	for _, member := range p.ordered() {
+		if !member.breaker.allow() {
+			continue
+		}
+
+		attempted++
+		out, err := p.attempt(ctx, member, in, opts...)
+		if err == nil {
+			member.breaker.success()
+			return out, nil
+		}
+
+		if ctx.Err() != nil {
+			// The caller gave up, this is not the endpoint's fault and no other endpoint can do better.
+			// An attempt reaching its own timeout on the other hand counts against its endpoint.
+			return nil, err
+		}
+
+		// Kept as is, wrapping it would hide the gRPC status from callers
+		lastErr = err
+		p.logger.Debug("collector endpoint failed to receive events", zap.String("endpoint", member.endpoint), zap.Error(err))
+		if member.breaker.failure() {
+			p.logger.Warn("collector endpoint marked unhealthy, skipping it until cooldown expires",
+				zap.String("endpoint", member.endpoint),
+				zap.Duration("cooldown", member.breaker.cooldown),
+				zap.Error(err),
+			)
+		}
+	}
+
+	if attempted == 0 {
+		return nil, fmt.Errorf("all %d collector endpoints are unhealthy", len(p.members))
+	}
+
+	return nil, lastErr
+}
+
+func (p *clientPool) attempt(ctx context.Context, member *poolMember, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	if p.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.attemptTimeout)
+		defer cancel()
+	}
+
+	return member.client.Emit(ctx, in, opts...)
+}
+
+func (p *clientPool) ordered() []*poolMember {
+	if p.strategy != LoadBalancingRoundRobin || len(p.members) <= 1 {
+		return p.members
+	}
+
+	start := int(atomic.AddUint64(&p.next, 1)-1) % len(p.members)
+	out := make([]*poolMember, 0, len(p.members))
+	out = append(out, p.members[start:]...)
+	return append(out, p.members[:start]...)
+}
+
+// circuitBreaker opens after threshold consecutive failures, once open a single
+// trial call is allowed per cooldown period until one succeeds and closes it again.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     clock.Clock
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, clock clock.Clock) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		clock:     clock,
+	}
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+
+	if b.clock.Now().Sub(b.openedAt) >= b.cooldown {
+		// Half-open, let this call through and wait a full cooldown before the next trial
+		b.openedAt = b.clock.Now()
+		return true
+	}
+
+	return false
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+}
+
+// failure records a failed call and returns true if it caused the breaker to open.
+func (b *circuitBreaker) failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return false
+	}
+
+	b.openedAt = b.clock.Now()
+	return b.failures == b.threshold
+}
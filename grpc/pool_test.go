@@ -0,0 +1,136 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering/clock"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type endpointClient struct {
+	fail  bool
+	calls int
+}
+
+func (c *endpointClient) Emit(ctx context.Context, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.calls++
+	if c.fail {
+		return nil, errors.New("unavailable")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func TestClientPool_Failover(t *testing.T) {
+	primary, secondary := &endpointClient{fail: true}, &endpointClient{}
+
+	clock := clock.NewFake(time.Unix(0, 0))
+	pool := newClientPool(LoadBalancingFailover, 0, zlog)
+	newBreaker := func() *circuitBreaker {
+		return newCircuitBreaker(2, time.Minute, clock)
+	}
+	pool.add("primary:9000", primary, newBreaker())
+	pool.add("secondary:9000", secondary, newBreaker())
+
+	for i := 0; i < 4; i++ {
+		_, err := pool.Emit(context.Background(), &pbmetering.Events{})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, primary.calls, "primary should be skipped once its breaker opened")
+	assert.Equal(t, 4, secondary.calls)
+
+	primary.fail = false
+	clock.Advance(time.Minute)
+
+	_, err := pool.Emit(context.Background(), &pbmetering.Events{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, primary.calls, "primary should receive a trial call after cooldown")
+	assert.Equal(t, 4, secondary.calls)
+
+	_, err = pool.Emit(context.Background(), &pbmetering.Events{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, primary.calls, "primary should be back in use after a successful trial")
+}
+
+func TestClientPool_RoundRobin(t *testing.T) {
+	clients := []*endpointClient{{}, {}, {}}
+
+	pool := newClientPool(LoadBalancingRoundRobin, 0, zlog)
+	for i, client := range clients {
+		pool.add(string(rune('a'+i))+":9000", client, newCircuitBreaker(3, time.Minute, clock.Real))
+	}
+
+	for i := 0; i < 9; i++ {
+		_, err := pool.Emit(context.Background(), &pbmetering.Events{})
+		require.NoError(t, err)
+	}
+
+	for _, client := range clients {
+		assert.Equal(t, 3, client.calls)
+	}
+}
+
+func TestClientPool_AllUnhealthy(t *testing.T) {
+	client := &endpointClient{fail: true}
+
+	pool := newClientPool(LoadBalancingFailover, 0, zlog)
+	pool.add("a:9000", client, newCircuitBreaker(1, time.Minute, clock.Real))
+
+	_, err := pool.Emit(context.Background(), &pbmetering.Events{})
+	assert.EqualError(t, err, "unavailable")
+
+	_, err = pool.Emit(context.Background(), &pbmetering.Events{})
+	assert.EqualError(t, err, "all 1 collector endpoints are unhealthy")
+	assert.Equal(t, 1, client.calls)
+}
+
+type hungClient struct {
+	calls int
+}
+
+func (c *hungClient) Emit(ctx context.Context, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.calls++
+	<-ctx.Done()
+	return nil, status.FromContextError(ctx.Err()).Err()
+}
+
+func TestClientPool_HungEndpoint(t *testing.T) {
+	primary, secondary := &hungClient{}, &endpointClient{}
+
+	pool := newClientPool(LoadBalancingFailover, 10*time.Millisecond, zlog)
+	pool.add("primary:9000", primary, newCircuitBreaker(2, time.Minute, clock.Real))
+	pool.add("secondary:9000", secondary, newCircuitBreaker(2, time.Minute, clock.Real))
+
+	for i := 0; i < 3; i++ {
+		_, err := pool.Emit(context.Background(), &pbmetering.Events{})
+		require.NoError(t, err, "batch should fail over once the primary attempt times out")
+	}
+
+	assert.Equal(t, 2, primary.calls, "timed out attempts should open the primary's breaker")
+	assert.Equal(t, 3, secondary.calls)
+}
+
+func TestClientPool_ParentContextDone(t *testing.T) {
+	primary, secondary := &hungClient{}, &endpointClient{}
+
+	pool := newClientPool(LoadBalancingFailover, time.Minute, zlog)
+	pool.add("primary:9000", primary, newCircuitBreaker(1, time.Minute, clock.Real))
+	pool.add("secondary:9000", secondary, newCircuitBreaker(1, time.Minute, clock.Real))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := pool.Emit(ctx, &pbmetering.Events{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, 0, secondary.calls, "no other endpoint is tried once the caller gave up")
+	assert.True(t, pool.members[0].breaker.allow(), "caller giving up is not the endpoint's fault")
+}
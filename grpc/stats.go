@@ -0,0 +1,92 @@
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+)
+
+// unhealthyAfterFailures is the number of consecutive batches that must fail
+// to be sent for the emitter to be reported unhealthy, so that a single
+// transient failure doesn't flap health checks.
+const unhealthyAfterFailures = 3
+
+// emitterStats tracks the activity reported by emitter.Stats, counters are
+// updated from both Emit callers and the launch loop.
+type emitterStats struct {
+	// batched is the size of the batch being accumulated by the launch loop
+	batched  atomic.Int64
+	inFlight atomic.Int64
+
+	emitted atomic.Uint64
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+
+	mu                  sync.Mutex
+	lastSuccess         time.Time
+	lastError           error
+	lastErrorAt         time.Time
+	consecutiveFailures int
+}
+
+func (s *emitterStats) sent(count int, at time.Time) {
+	s.emitted.Add(uint64(count))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSuccess = at
+	s.consecutiveFailures = 0
+}
+
+func (s *emitterStats) sendFailed(count int, err error, at time.Time) {
+	s.failed.Add(uint64(count))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err
+	s.lastErrorAt = at
+	s.consecutiveFailures++
+}
+
+func (e *emitter) Stats() dmetering.Stats {
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+
+	return dmetering.Stats{
+		Queued:      len(e.buffer) + int(e.stats.batched.Load()),
+		InFlight:    int(e.stats.inFlight.Load()),
+		LastSuccess: e.stats.lastSuccess,
+		LastError:   e.stats.lastError,
+		LastErrorAt: e.stats.lastErrorAt,
+		Emitted:     e.stats.emitted.Load(),
+		Dropped:     e.stats.dropped.Load(),
+		Failed:      e.stats.failed.Load(),
+	}
+}
+
+// Healthy reports the emitter unhealthy while it's shutting down, when its
+// buffer is full or when the last unhealthyAfterFailures sends to the
+// collector failed.
+func (e *emitter) Healthy() error {
+	if e.IsTerminating() {
+		return errors.New("emitter is shutting down")
+	}
+
+	// An unbuffered emitter hands events over to the launch loop directly
+	if queued := len(e.buffer); cap(e.buffer) > 0 && queued >= cap(e.buffer) {
+		return fmt.Errorf("buffer is full with %d events, new events are dropped", queued)
+	}
+
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+
+	if failures := e.stats.consecutiveFailures; failures >= unhealthyAfterFailures {
+		return fmt.Errorf("last %d sends to collector failed: %w", failures, e.stats.lastError)
+	}
+
+	return nil
+}
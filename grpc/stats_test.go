@@ -0,0 +1,115 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// controlledClient hands every batch to the test and returns the error the
+// test answers with.
+type controlledClient struct {
+	calls   chan *pbmetering.Events
+	results chan error
+}
+
+func (c *controlledClient) Emit(ctx context.Context, in *pbmetering.Events, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	c.calls <- in
+	if err := <-c.results; err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func TestEmitter_StatsAndHealth(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := clock.NewFake(start)
+	client := &controlledClient{calls: make(chan *pbmetering.Events), results: make(chan error)}
+
+	config := &Config{
+		Endpoint:   "localhost:9000",
+		Delay:      time.Second,
+		BufferSize: 100,
+		Network:    "eth-testnet",
+		Clock:      clock,
+	}
+	plugin, err := newWithClient(config, client, func() error { return nil }, zlog)
+	require.NoError(t, err)
+	clock.BlockUntil(1)
+
+	provider, ok := plugin.(dmetering.StatsProvider)
+	require.True(t, ok)
+	assert.NoError(t, provider.Healthy())
+
+	plugin.Emit(context.Background(), dmetering.Event{})
+	plugin.Emit(context.Background(), newEvent("read_bytes", 1))
+	plugin.Emit(context.Background(), newEvent("read_bytes", 2))
+
+	clock.Advance(time.Second)
+	assert.Len(t, (<-client.calls).Events, 2)
+
+	stats := provider.Stats()
+	assert.Equal(t, 1, stats.InFlight)
+	assert.Equal(t, 0, stats.Queued)
+	assert.Equal(t, uint64(1), stats.Dropped)
+
+	client.results <- errors.New("collector unavailable")
+
+	plugin.Emit(context.Background(), newEvent("read_bytes", 3))
+	clock.Advance(time.Second)
+	assert.Len(t, (<-client.calls).Events, 1)
+
+	stats = provider.Stats()
+	assert.Equal(t, uint64(2), stats.Failed)
+	assert.EqualError(t, stats.LastError, "collector unavailable")
+	assert.NoError(t, provider.Healthy(), "a single failed send doesn't make the emitter unhealthy")
+
+	for i := 0; i < unhealthyAfterFailures-1; i++ {
+		client.results <- errors.New("collector unavailable")
+
+		plugin.Emit(context.Background(), newEvent("read_bytes", 4))
+		clock.Advance(time.Second)
+		assert.Len(t, (<-client.calls).Events, 1)
+	}
+	assert.EqualError(t, provider.Healthy(), "last 3 sends to collector failed: collector unavailable")
+
+	client.results <- nil
+	assert.Eventually(t, func() bool { return provider.Healthy() == nil }, time.Second, time.Millisecond, "a successful send makes the emitter healthy again")
+	plugin.Shutdown(nil)
+
+	stats = provider.Stats()
+	assert.Equal(t, dmetering.Stats{
+		LastSuccess: start.Add(4 * time.Second),
+		LastError:   stats.LastError,
+		LastErrorAt: stats.LastErrorAt,
+		Emitted:     1,
+		Dropped:     1,
+		Failed:      4,
+	}, stats)
+	assert.False(t, stats.LastErrorAt.Before(start.Add(time.Second)))
+	assert.EqualError(t, provider.Healthy(), "emitter is shutting down")
+}
+
+func TestEmitter_HealthyUnbuffered(t *testing.T) {
+	client := &controlledClient{calls: make(chan *pbmetering.Events), results: make(chan error)}
+	config := &Config{
+		Endpoint: "localhost:9000",
+		Delay:    time.Second,
+		Network:  "eth-testnet",
+		Clock:    clock.NewFake(time.Unix(0, 0)),
+	}
+	plugin, err := newWithClient(config, client, func() error { return nil }, zlog)
+	require.NoError(t, err)
+	defer plugin.Shutdown(nil)
+
+	assert.NoError(t, plugin.(dmetering.StatsProvider).Healthy(), "an unbuffered emitter is not full")
+}
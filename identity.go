@@ -0,0 +1,81 @@
+package dmetering
+
+import (
+	"context"
+
+	"github.com/streamingfast/dmetering/clock"
+)
+
+type identityKey string
+
+const identityContextKey = identityKey("identity")
+
+// Identity is who an event is attributed to, it's usually resolved once by the
+// authentication layer and carried in the request's context.
+type Identity struct {
+	UserID    string
+	ApiKeyID  string
+	IpAddress string
+}
+
+func WithIdentity(ctx context.Context, userID, apiKeyID, ipAddress string) context.Context {
+	return context.WithValue(ctx, identityContextKey, Identity{
+		UserID:    userID,
+		ApiKeyID:  apiKeyID,
+		IpAddress: ipAddress,
+	})
+}
+
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey).(Identity)
+	return identity, ok
+}
+
+// NewDefaultingEmitter wraps next so that events missing their UserID, ApiKeyID
+// or IpAddress get them from the identity found in the context passed to Emit,
+// and events with a zero Timestamp are stamped with the time of clk, the wall
+// clock when nil. Fields already set on the event are never overridden.
+func NewDefaultingEmitter(next EventEmitter, clk clock.Clock) EventEmitter {
+	return &defaultingEmitter{
+		next:  next,
+		clock: clock.OrReal(clk),
+	}
+}
+
+type defaultingEmitter struct {
+	next  EventEmitter
+	clock clock.Clock
+}
+
+func (e *defaultingEmitter) Emit(ctx context.Context, ev Event) {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		if ev.UserID == "" {
+			ev.UserID = identity.UserID
+		}
+		if ev.ApiKeyID == "" {
+			ev.ApiKeyID = identity.ApiKeyID
+		}
+		if ev.IpAddress == "" {
+			ev.IpAddress = identity.IpAddress
+		}
+	}
+
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = e.clock.Now()
+	}
+
+	e.next.Emit(ctx, ev)
+}
+
+func (e *defaultingEmitter) Shutdown(err error) {
+	e.next.Shutdown(err)
+}
+
+func (e *defaultingEmitter) Stats() Stats {
+	stats, _ := EmitterStats(e.next)
+	return stats
+}
+
+func (e *defaultingEmitter) Healthy() error {
+	return EmitterHealthy(e.next)
+}
@@ -0,0 +1,30 @@
+package dmetering_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultingEmitter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	next := dmeteringtest.NewRecorder()
+	emitter := dmetering.NewDefaultingEmitter(next, clock.NewFake(now))
+
+	ctx := dmetering.WithIdentity(context.Background(), "user-1", "key-1", "10.0.0.1")
+
+	emitter.Emit(ctx, dmetering.Event{Endpoint: "sf.firehose.v2/Blocks"})
+	emitter.Emit(ctx, dmetering.Event{Endpoint: "sf.firehose.v2/Blocks", UserID: "user-2", Timestamp: now.Add(-time.Hour)})
+	emitter.Emit(context.Background(), dmetering.Event{Endpoint: "sf.firehose.v2/Blocks"})
+
+	assert.Equal(t, []dmetering.Event{
+		{Endpoint: "sf.firehose.v2/Blocks", UserID: "user-1", ApiKeyID: "key-1", IpAddress: "10.0.0.1", Timestamp: now},
+		{Endpoint: "sf.firehose.v2/Blocks", UserID: "user-2", ApiKeyID: "key-1", IpAddress: "10.0.0.1", Timestamp: now.Add(-time.Hour)},
+		{Endpoint: "sf.firehose.v2/Blocks", Timestamp: now},
+	}, next.Events())
+}
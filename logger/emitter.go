@@ -2,29 +2,47 @@ package logger
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 
 	"github.com/streamingfast/dmetering"
 	"go.uber.org/zap"
 )
 
 func Register() {
-	dmetering.Register("logger", func(_ string, logger *zap.Logger) (dmetering.EventEmitter, error) {
-		return new(logger), nil
+	dmetering.Register("logger", func(config string, logger *zap.Logger) (dmetering.EventEmitter, error) {
+		u, err := url.Parse(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logger config: %w", err)
+		}
+
+		unknownMetrics, err := dmetering.ParseUnknownMetricPolicy(u.Query().Get("unknownMetrics"))
+		if err != nil {
+			return nil, err
+		}
+
+		return new(logger, unknownMetrics), nil
 	})
 }
 
 type emitter struct {
-	logger *zap.Logger
+	logger         *zap.Logger
+	unknownMetrics dmetering.UnknownMetricPolicy
 }
 
 func (l *emitter) Emit(_ context.Context, event dmetering.Event) {
+	if !l.unknownMetrics.Check(event, l.logger) {
+		return
+	}
+
 	l.logger.Info("emit", zap.Object("event", event))
 }
 
 func (l *emitter) Shutdown(error) {}
 
-func new(logger *zap.Logger) dmetering.EventEmitter {
+func new(logger *zap.Logger, unknownMetrics dmetering.UnknownMetricPolicy) dmetering.EventEmitter {
 	return &emitter{
-		logger: logger,
+		logger:         logger,
+		unknownMetrics: unknownMetrics,
 	}
 }
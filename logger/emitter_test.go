@@ -0,0 +1,15 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	"go.uber.org/zap"
+)
+
+func TestEmitter_Conformance(t *testing.T) {
+	dmeteringtest.RunEmitterConformance(t, func(t *testing.T) (dmetering.EventEmitter, func() []dmetering.Event) {
+		return new(zap.NewNop(), dmetering.UnknownMetricPolicy("")), nil
+	})
+}
@@ -2,11 +2,21 @@ package dmetering
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	tracing "github.com/streamingfast/sf-tracing"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -20,12 +30,92 @@ type Event struct {
 	ApiKeyID  string `json:"api_key_id"`
 	IpAddress string `json:"ip_address"`
 
+	// Meta is free-form metadata kept for compatibility, prefer Labels for structured data.
 	Meta string `json:"meta"`
+	// Labels is structured metadata attached to the event, like the output module
+	// hash or the substreams package.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// TraceID and SpanID are the hex encoded identifiers of the span active when
+	// the event was emitted, see WithTrace.
+	TraceID string `json:"trace_id,omitempty"`
+	SpanID  string `json:"span_id,omitempty"`
 
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// WithTrace returns ev with TraceID and SpanID set from the span active in ctx.
+// Identifiers already set on ev are kept, as well as empty ones when ctx holds
+// no valid trace.
+func (ev Event) WithTrace(ctx context.Context) Event {
+	if ev.TraceID != "" {
+		return ev
+	}
+
+	traceID := tracing.GetTraceID(ctx)
+	if !traceID.IsValid() {
+		return ev
+	}
+
+	ev.TraceID = traceID.String()
+	if spanID := trace.SpanContextFromContext(ctx).SpanID(); spanID.IsValid() {
+		ev.SpanID = spanID.String()
+	}
+	return ev
+}
+
+// AddLogRedactor installs a function applied to every event before it's encoded
+// by MarshalLogObject, so that personal data does not end up in logs whatever
+// the emitter logging it. When several redactors are installed, events go
+// through all of them in installation order. The returned function removes the
+// redactor.
+func AddLogRedactor(redactor func(Event) Event) (remove func()) {
+	entry := &logRedactor{redact: redactor}
+
+	logRedactorsLock.Lock()
+	defer logRedactorsLock.Unlock()
+	redactors := append(append([]*logRedactor{}, loadLogRedactors()...), entry)
+	logRedactors.Store(&redactors)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			logRedactorsLock.Lock()
+			defer logRedactorsLock.Unlock()
+
+			var redactors []*logRedactor
+			for _, installed := range loadLogRedactors() {
+				if installed != entry {
+					redactors = append(redactors, installed)
+				}
+			}
+			logRedactors.Store(&redactors)
+		})
+	}
+}
+
+type logRedactor struct {
+	redact func(Event) Event
+}
+
+var (
+	logRedactorsLock sync.Mutex
+	// logRedactors is replaced as a whole so that encoding events doesn't lock
+	logRedactors atomic.Pointer[[]*logRedactor]
+)
+
+func loadLogRedactors() []*logRedactor {
+	if redactors := logRedactors.Load(); redactors != nil {
+		return *redactors
+	}
+	return nil
+}
+
 func (ev Event) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, redactor := range loadLogRedactors() {
+		ev = redactor.redact(ev)
+	}
+
 	if ev.UserID != "" {
 		enc.AddString("user_id", ev.UserID)
 	}
@@ -43,9 +133,29 @@ func (ev Event) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 		enc.AddFloat64(k, v)
 	}
 
+	if len(ev.Labels) > 0 {
+		enc.AddObject("labels", labels(ev.Labels))
+	}
+
+	if ev.TraceID != "" {
+		enc.AddString("trace_id", ev.TraceID)
+		enc.AddString("span_id", ev.SpanID)
+	}
+
 	return nil
 }
 
+type labels map[string]string
+
+func (l labels) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for k, v := range l {
+		enc.AddString(k, v)
+	}
+	return nil
+}
+
+// ToProto converts the event to its protobuf representation for network. Metrics
+// are sorted by key so that the output is deterministic.
 func (ev Event) ToProto(network string) *pbmetering.Event {
 	pbev := new(pbmetering.Event)
 	pbev.Endpoint = ev.Endpoint
@@ -55,32 +165,223 @@ func (ev Event) ToProto(network string) *pbmetering.Event {
 	pbev.ApiKeyId = ev.ApiKeyID
 	pbev.IpAddress = ev.IpAddress
 	pbev.Meta = ev.Meta
+	pbev.TraceId = ev.TraceID
+	pbev.SpanId = ev.SpanID
 
-	pbev.Metrics = []*pbmetering.Metric{}
-	for k, v := range ev.Metrics {
-		pbev.Metrics = append(pbev.Metrics, &pbmetering.Metric{
+	if len(ev.Labels) > 0 {
+		pbev.Labels = make(map[string]string, len(ev.Labels))
+		for k, v := range ev.Labels {
+			pbev.Labels[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(ev.Metrics))
+	for k := range ev.Metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pbev.Metrics = make([]*pbmetering.Metric, 0, len(keys))
+	for _, k := range keys {
+		metric := &pbmetering.Metric{
 			Key:   k,
-			Value: v,
-		})
+			Value: ev.Metrics[k],
+		}
+		if def, found := LookupMetric(k); found {
+			metric.Unit = string(def.Unit)
+		}
+
+		pbev.Metrics = append(pbev.Metrics, metric)
 	}
 
 	return pbev
 }
 
+// EventFromProto is the inverse of Event.ToProto, it returns the event along with
+// the network it was emitted for. Empty metrics and labels are returned as nil
+// maps and, should a metric key appear more than once, the last value wins.
+func EventFromProto(pbev *pbmetering.Event) (ev Event, network string) {
+	ev = Event{
+		Endpoint:  pbev.Endpoint,
+		UserID:    pbev.UserId,
+		ApiKeyID:  pbev.ApiKeyId,
+		IpAddress: pbev.IpAddress,
+		Meta:      pbev.Meta,
+		TraceID:   pbev.TraceId,
+		SpanID:    pbev.SpanId,
+	}
+
+	if pbev.Timestamp != nil {
+		ev.Timestamp = pbev.Timestamp.AsTime()
+	}
+
+	if len(pbev.Labels) > 0 {
+		ev.Labels = make(map[string]string, len(pbev.Labels))
+		for k, v := range pbev.Labels {
+			ev.Labels[k] = v
+		}
+	}
+
+	if len(pbev.Metrics) > 0 {
+		ev.Metrics = make(map[string]float64, len(pbev.Metrics))
+		for _, metric := range pbev.Metrics {
+			ev.Metrics[metric.Key] = metric.Value
+		}
+	}
+
+	return ev, pbev.Network
+}
+
 type EventEmitter interface {
 	Shutdown(error)
 	Emit(ctx context.Context, ev Event)
 }
 
+// New creates the emitter described by the config DSN using the plugin registered
+// for its scheme, an unknown scheme is an error. Any `${ENV_VAR}` reference in config is replaced by the value of
+// the environment variable beforehand, so that secrets do not have to appear in
+// process listings. Values are query-escaped when referenced in the query string
+// and errors show the reference instead of the value.
 func New(config string, logger *zap.Logger) (EventEmitter, error) {
-	u, err := url.Parse(config)
+	config, references, err := expandEnv(config)
 	if err != nil {
 		return nil, err
 	}
 
+	u, err := url.Parse(config)
+	if err != nil {
+		// The url.Error message holds the whole DSN
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, references.redact(fmt.Errorf("invalid config: %w", err))
+	}
+
 	factory := registry[u.Scheme]
 	if factory == nil {
-		panic(fmt.Sprintf("no Metering plugin named \"%s\" is currently registered.", u.Scheme))
+		return nil, fmt.Errorf("no plugin named %q, registered plugins are %q", u.Scheme, RegisteredPlugins())
 	}
-	return factory(config, logger)
+
+	emitter, err := factory(config, logger)
+	if err != nil {
+		return nil, references.redact(err)
+	}
+	return emitter, nil
 }
+
+var envReferenceRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// envReferences maps the quoted values of the config options that referenced
+// environment variables, as they appear in errors formatted with %q, to their
+// quoted unexpanded form.
+type envReferences map[string]string
+
+func expandEnv(config string) (string, envReferences, error) {
+	var missing []string
+	references := envReferences{}
+
+	expandReference := func(escape func(string) string) func(string) string {
+		return func(reference string) string {
+			name := envReferenceRegex.FindStringSubmatch(reference)[1]
+			value, found := os.LookupEnv(name)
+			if !found {
+				missing = append(missing, name)
+			}
+			return escape(value)
+		}
+	}
+
+	// expand expands every part of in between separators on its own so that
+	// errors quoting an option value or an endpoint can be mapped back to it
+	expand := func(in, separators string, escape func(string) string) string {
+		var out strings.Builder
+		for in != "" {
+			end := strings.IndexAny(in, separators)
+			if end < 0 {
+				end = len(in)
+			}
+
+			part := in[:end]
+			expanded := envReferenceRegex.ReplaceAllStringFunc(part, expandReference(escape))
+			if expanded != part {
+				references.add(part, expanded)
+			}
+			out.WriteString(expanded)
+
+			if end < len(in) {
+				out.WriteByte(in[end])
+				end++
+			}
+			in = in[end:]
+		}
+		return out.String()
+	}
+
+	head, query, hasQuery := strings.Cut(config, "?")
+	scheme, rest, hasScheme := strings.Cut(head, "://")
+	if hasScheme {
+		head = expand(scheme, "", identity) + "://" + expand(rest, ",/@", identity)
+	} else {
+		head = expand(head, ",/@", identity)
+	}
+
+	// Values in the query string are escaped so that characters like `&` or `+`
+	// in a token do not change the parsed options
+	out := head
+	if hasQuery {
+		out += "?" + expand(query, "&=", url.QueryEscape)
+	}
+
+	if len(missing) > 0 {
+		return "", nil, fmt.Errorf("environment variables %q referenced in config are not set", missing)
+	}
+
+	return out, references, nil
+}
+
+func identity(in string) string { return in }
+
+// add records that original expanded to expanded, both as is and query
+// unescaped like options are once parsed.
+func (r envReferences) add(original, expanded string) {
+	r[strconv.Quote(expanded)] = strconv.Quote(original)
+
+	if unescaped, err := url.QueryUnescape(expanded); err == nil && unescaped != expanded {
+		if unescapedOriginal, err := url.QueryUnescape(original); err == nil {
+			original = unescapedOriginal
+		}
+		r[strconv.Quote(unescaped)] = strconv.Quote(original)
+	}
+}
+
+// redact returns err with every quoted value expanded from environment
+// variables in its message replaced by its quoted reference, err stays
+// reachable through errors.Unwrap. Only whole quoted values are replaced so
+// that short values do not alter the rest of the message.
+func (r envReferences) redact(err error) error {
+	if len(r) == 0 {
+		return err
+	}
+
+	// Longest values first, a value may be quoted in another one
+	quoted := make([]string, 0, len(r))
+	for value := range r {
+		quoted = append(quoted, value)
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+
+	message := err.Error()
+	for _, value := range quoted {
+		message = strings.ReplaceAll(message, value, r[value])
+	}
+	return &redactedError{message: message, err: err}
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string { return e.message }
+func (e *redactedError) Unwrap() error { return e.err }
@@ -2,18 +2,73 @@ package dmetering
 
 import (
 	"context"
+	"sync/atomic"
 )
 
-var defaultMeter EventEmitter = newNullEmitter()
+type emitterKey string
 
+const emitterContextKey = emitterKey("emitter")
+
+// emitterHolder wraps the default emitter so it can be swapped atomically.
+type emitterHolder struct {
+	emitter EventEmitter
+}
+
+var defaultMeter atomic.Pointer[emitterHolder]
+
+func init() {
+	defaultMeter.Store(&emitterHolder{emitter: newNullEmitter()})
+}
+
+// SetDefaultEmitter replaces the default emitter, a nil emitter resets it to a
+// null one. The previous emitter is left untouched, see ReplaceDefaultEmitter
+// to also shut it down.
 func SetDefaultEmitter(m EventEmitter) {
-	defaultMeter = m
+	SwapDefaultEmitter(m)
+}
+
+// SwapDefaultEmitter atomically replaces the default emitter and returns the
+// previous one.
+func SwapDefaultEmitter(m EventEmitter) (previous EventEmitter) {
+	if m == nil {
+		m = newNullEmitter()
+	}
+
+	return defaultMeter.Swap(&emitterHolder{emitter: m}).emitter
+}
+
+// ReplaceDefaultEmitter atomically replaces the default emitter and shuts the
+// previous one down with err, flushing whatever it still buffers.
+func ReplaceDefaultEmitter(m EventEmitter, err error) {
+	SwapDefaultEmitter(m).Shutdown(err)
 }
 
 func GetDefaultEmitter() EventEmitter {
-	return defaultMeter
+	return defaultMeter.Load().emitter
+}
+
+// WithEmitter returns a context carrying e, Emit calls made with it (or any
+// context derived from it) are sent to e instead of the default emitter.
+func WithEmitter(ctx context.Context, e EventEmitter) context.Context {
+	if e == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, emitterContextKey, e)
+}
+
+// EmitterFromContext returns the emitter attached to ctx by WithEmitter, or the
+// default emitter if there is none.
+func EmitterFromContext(ctx context.Context) EventEmitter {
+	if e, ok := ctx.Value(emitterContextKey).(EventEmitter); ok {
+		return e
+	}
+
+	return GetDefaultEmitter()
 }
 
+// Emit sends event to the emitter of ctx (see EmitterFromContext), the trace
+// and span active in ctx are captured on the event beforehand.
 func Emit(ctx context.Context, event Event) {
-	defaultMeter.Emit(ctx, event)
+	EmitterFromContext(ctx).Emit(ctx, event.WithTrace(ctx))
 }
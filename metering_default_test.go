@@ -0,0 +1,100 @@
+package dmetering_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	tracing "github.com/streamingfast/sf-tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type shutdownTrackingEmitter struct {
+	*dmeteringtest.Recorder
+	shutdownErr error
+	shutdown    bool
+}
+
+func (e *shutdownTrackingEmitter) Shutdown(err error) {
+	e.shutdown = true
+	e.shutdownErr = err
+}
+
+func TestEmit_ContextEmitter(t *testing.T) {
+	global, scoped := dmeteringtest.NewRecorder(), dmeteringtest.NewRecorder()
+	previous := dmetering.SwapDefaultEmitter(global)
+	defer dmetering.SetDefaultEmitter(previous)
+
+	ctx := dmetering.WithEmitter(context.Background(), scoped)
+	dmetering.Emit(ctx, dmetering.Event{Endpoint: "scoped"})
+	derived, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dmetering.Emit(derived, dmetering.Event{Endpoint: "derived"})
+	dmetering.Emit(context.Background(), dmetering.Event{Endpoint: "global"})
+
+	assert.Equal(t, []dmetering.Event{{Endpoint: "scoped"}, {Endpoint: "derived"}}, scoped.Events())
+	assert.Equal(t, []dmetering.Event{{Endpoint: "global"}}, global.Events())
+	assert.Equal(t, dmetering.GetDefaultEmitter(), dmetering.EmitterFromContext(dmetering.WithEmitter(context.Background(), nil)))
+}
+
+func TestEmit_CapturesTrace(t *testing.T) {
+	recorder := dmeteringtest.NewRecorder()
+	ctx := dmetering.WithEmitter(context.Background(), recorder)
+
+	traceID := tracing.NewFixedTraceID("0123456789abcdef0123456789abcdef")
+	spanID := trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8}
+	traced := trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+
+	dmetering.Emit(ctx, dmetering.Event{Endpoint: "untraced"})
+	dmetering.Emit(traced, dmetering.Event{Endpoint: "traced"})
+	dmetering.Emit(traced, dmetering.Event{Endpoint: "explicit", TraceID: "fedcba9876543210fedcba9876543210"})
+
+	assert.Equal(t, []dmetering.Event{
+		{Endpoint: "untraced"},
+		{Endpoint: "traced", TraceID: "0123456789abcdef0123456789abcdef", SpanID: "0102030405060708"},
+		{Endpoint: "explicit", TraceID: "fedcba9876543210fedcba9876543210"},
+	}, recorder.Events())
+
+	pbev := recorder.Events()[1].ToProto("eth-mainnet")
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", pbev.TraceId)
+	assert.Equal(t, "0102030405060708", pbev.SpanId)
+}
+
+func TestReplaceDefaultEmitter(t *testing.T) {
+	first := &shutdownTrackingEmitter{Recorder: dmeteringtest.NewRecorder()}
+	second := &shutdownTrackingEmitter{Recorder: dmeteringtest.NewRecorder()}
+	previous := dmetering.SwapDefaultEmitter(first)
+	defer dmetering.SetDefaultEmitter(previous)
+
+	dmetering.ReplaceDefaultEmitter(second, context.Canceled)
+	assert.True(t, first.shutdown)
+	assert.Equal(t, context.Canceled, first.shutdownErr)
+	assert.False(t, second.shutdown)
+	assert.Equal(t, dmetering.EventEmitter(second), dmetering.GetDefaultEmitter())
+
+	dmetering.SetDefaultEmitter(nil)
+	dmetering.Emit(context.Background(), dmetering.Event{Endpoint: "sf.firehose.v2/Blocks"})
+	assert.Empty(t, second.Events(), "a nil default emitter must not keep the previous one")
+}
+
+func TestSetDefaultEmitter_concurrent(t *testing.T) {
+	previous := dmetering.GetDefaultEmitter()
+	defer dmetering.SetDefaultEmitter(previous)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			dmetering.SetDefaultEmitter(dmeteringtest.NewRecorder())
+		}()
+		go func() {
+			defer wg.Done()
+			dmetering.Emit(context.Background(), dmetering.Event{Endpoint: "sf.firehose.v2/Blocks"})
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,129 @@
+package dmetering
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEvent_Labels(t *testing.T) {
+	ev := Event{
+		Endpoint: "sf.substreams.rpc.v2/Blocks",
+		Metrics:  map[string]float64{"read_bytes": 10},
+		Meta:     "legacy",
+		Labels: map[string]string{
+			"output_module_hash": "a1b2c3",
+			"trace_id":           "0af7651916cd43dd8448eb211c80319c",
+		},
+		Timestamp: time.Unix(1700000000, 0).UTC(),
+	}
+
+	pbev := ev.ToProto("eth-mainnet")
+	assert.Equal(t, ev.Labels, pbev.Labels)
+	assert.Equal(t, "legacy", pbev.Meta)
+
+	ev.Labels["output_module_hash"] = "changed"
+	assert.Equal(t, "a1b2c3", pbev.Labels["output_module_hash"], "proto labels must not alias the event's map")
+
+	content, err := json.Marshal(Event{Endpoint: "sf.firehose.v2/Blocks", Labels: map[string]string{"package": "uniswap-v3"}})
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"labels":{"package":"uniswap-v3"}`)
+
+	enc := zapcore.NewMapObjectEncoder()
+	require.NoError(t, ev.MarshalLogObject(enc))
+	assert.Equal(t, map[string]interface{}{
+		"output_module_hash": "changed",
+		"trace_id":           "0af7651916cd43dd8448eb211c80319c",
+	}, enc.Fields["labels"])
+}
+
+type randomEvent struct {
+	Event
+	Network string
+}
+
+func (randomEvent) Generate(r *rand.Rand, size int) reflect.Value {
+	randomString := func() string {
+		value, _ := quick.Value(reflect.TypeOf(""), r)
+		return value.String()
+	}
+
+	ev := randomEvent{
+		Event: Event{
+			Endpoint:  randomString(),
+			UserID:    randomString(),
+			ApiKeyID:  randomString(),
+			IpAddress: randomString(),
+			Meta:      randomString(),
+			TraceID:   randomString(),
+			SpanID:    randomString(),
+			Timestamp: time.Unix(r.Int63n(1<<35)-(1<<34), r.Int63n(int64(time.Second))).UTC(),
+		},
+		Network: randomString(),
+	}
+
+	if count := r.Intn(size + 1); count > 0 {
+		ev.Metrics = make(map[string]float64, count)
+		for i := 0; i < count; i++ {
+			ev.Metrics[randomString()] = r.NormFloat64() * 1e9
+		}
+	}
+
+	if count := r.Intn(size + 1); count > 0 {
+		ev.Labels = make(map[string]string, count)
+		for i := 0; i < count; i++ {
+			ev.Labels[randomString()] = randomString()
+		}
+	}
+
+	return reflect.ValueOf(ev)
+}
+
+func TestEventFromProto_RoundTrip(t *testing.T) {
+	roundTrip := func(in randomEvent) bool {
+		out, network := EventFromProto(in.ToProto(in.Network))
+
+		return network == in.Network && out.Timestamp.Equal(in.Timestamp) && assert.ObjectsAreEqual(in.Event, out)
+	}
+
+	require.NoError(t, quick.Check(roundTrip, &quick.Config{MaxCount: 1000}))
+}
+
+func TestEventFromProto_ProtoRoundTrip(t *testing.T) {
+	roundTrip := func(in randomEvent) bool {
+		pbev := in.ToProto(in.Network)
+		ev, network := EventFromProto(pbev)
+
+		return proto.Equal(pbev, ev.ToProto(network))
+	}
+
+	require.NoError(t, quick.Check(roundTrip, &quick.Config{MaxCount: 1000}))
+}
+
+func TestEvent_ToProto_deterministic(t *testing.T) {
+	ev := Event{Metrics: map[string]float64{"c": 3, "a": 1, "b": 2, MetricReadBytes: 4}}
+
+	var keys []string
+	for _, metric := range ev.ToProto("eth-mainnet").Metrics {
+		keys = append(keys, metric.Key)
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", MetricReadBytes}, keys)
+}
+
+func TestEventFromProto_zeroTimestamp(t *testing.T) {
+	ev, _ := EventFromProto(&pbmetering.Event{Endpoint: "sf.firehose.v2/Blocks"})
+	assert.True(t, ev.Timestamp.IsZero())
+
+	ev, _ = EventFromProto(Event{}.ToProto("eth-mainnet"))
+	assert.True(t, ev.Timestamp.IsZero())
+}
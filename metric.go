@@ -0,0 +1,151 @@
+package dmetering
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Well-known metric keys, use them instead of literals so that a typo does not
+// silently create a new billing dimension.
+const (
+	MetricReadBytes    = "read_bytes"
+	MetricWrittenBytes = "written_bytes"
+	MetricEgressBytes  = "egress_bytes"
+	MetricMessageCount = "message_count"
+	MetricBlockCount   = "block_count"
+	MetricRequestCount = "request_count"
+	MetricDuration     = "duration_seconds"
+)
+
+type MetricUnit string
+
+const (
+	MetricUnitBytes    MetricUnit = "bytes"
+	MetricUnitCount    MetricUnit = "count"
+	MetricUnitSeconds  MetricUnit = "seconds"
+	MetricUnitCurrency MetricUnit = "currency"
+)
+
+type MetricKind string
+
+const (
+	// MetricKindCounter values are summed when events are aggregated.
+	MetricKindCounter MetricKind = "counter"
+	// MetricKindGauge values are point-in-time, aggregating them keeps the last one.
+	MetricKindGauge MetricKind = "gauge"
+)
+
+type MetricDefinition struct {
+	Name        string
+	Unit        MetricUnit
+	Kind        MetricKind
+	Description string
+}
+
+var metricDefinitionsLock sync.RWMutex
+var metricDefinitions = map[string]MetricDefinition{}
+
+func init() {
+	RegisterMetric(MetricDefinition{Name: MetricReadBytes, Unit: MetricUnitBytes, Kind: MetricKindCounter, Description: "Bytes read from storage to serve the request"})
+	RegisterMetric(MetricDefinition{Name: MetricWrittenBytes, Unit: MetricUnitBytes, Kind: MetricKindCounter, Description: "Bytes written to storage while serving the request"})
+	RegisterMetric(MetricDefinition{Name: MetricEgressBytes, Unit: MetricUnitBytes, Kind: MetricKindCounter, Description: "Bytes sent back to the client"})
+	RegisterMetric(MetricDefinition{Name: MetricMessageCount, Unit: MetricUnitCount, Kind: MetricKindCounter, Description: "Messages sent back to the client"})
+	RegisterMetric(MetricDefinition{Name: MetricBlockCount, Unit: MetricUnitCount, Kind: MetricKindCounter, Description: "Blocks processed to serve the request"})
+	RegisterMetric(MetricDefinition{Name: MetricRequestCount, Unit: MetricUnitCount, Kind: MetricKindCounter, Description: "Requests served"})
+	RegisterMetric(MetricDefinition{Name: MetricDuration, Unit: MetricUnitSeconds, Kind: MetricKindCounter, Description: "Time spent serving the request"})
+}
+
+// RegisterMetric makes a metric key known to the library, registering the same
+// name again replaces its definition.
+func RegisterMetric(def MetricDefinition) {
+	if def.Name == "" {
+		panic("metric definition must have a name")
+	}
+
+	metricDefinitionsLock.Lock()
+	defer metricDefinitionsLock.Unlock()
+
+	metricDefinitions[def.Name] = def
+}
+
+func LookupMetric(name string) (MetricDefinition, bool) {
+	metricDefinitionsLock.RLock()
+	defer metricDefinitionsLock.RUnlock()
+
+	def, found := metricDefinitions[name]
+	return def, found
+}
+
+// RegisteredMetrics returns all known metric definitions sorted by name.
+func RegisteredMetrics() []MetricDefinition {
+	metricDefinitionsLock.RLock()
+	defer metricDefinitionsLock.RUnlock()
+
+	out := make([]MetricDefinition, 0, len(metricDefinitions))
+	for _, def := range metricDefinitions {
+		out = append(out, def)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+
+	return out
+}
+
+// UnknownMetrics returns the sorted metric keys of ev that are not registered.
+func (ev Event) UnknownMetrics() []string {
+	var unknown []string
+	for k := range ev.Metrics {
+		if _, found := LookupMetric(k); !found {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+// UnknownMetricPolicy defines what an emitter does with an event carrying
+// metric keys that are not registered.
+type UnknownMetricPolicy string
+
+const (
+	// UnknownMetricAllow emits the event as is, it's the zero value.
+	UnknownMetricAllow UnknownMetricPolicy = ""
+	// UnknownMetricWarn emits the event but logs a warning.
+	UnknownMetricWarn UnknownMetricPolicy = "warn"
+	// UnknownMetricReject drops the event and logs a warning.
+	UnknownMetricReject UnknownMetricPolicy = "reject"
+)
+
+func ParseUnknownMetricPolicy(in string) (UnknownMetricPolicy, error) {
+	switch policy := UnknownMetricPolicy(in); policy {
+	case UnknownMetricAllow, UnknownMetricWarn, UnknownMetricReject:
+		return policy, nil
+	case "allow":
+		return UnknownMetricAllow, nil
+	}
+
+	return "", fmt.Errorf("invalid unknown metric policy %q, expected one of \"allow\", %q or %q", in, UnknownMetricWarn, UnknownMetricReject)
+}
+
+// Check applies the policy to ev and returns false if the event must be dropped.
+func (p UnknownMetricPolicy) Check(ev Event, logger *zap.Logger) bool {
+	if p == UnknownMetricAllow {
+		return true
+	}
+
+	unknown := ev.UnknownMetrics()
+	if len(unknown) == 0 {
+		return true
+	}
+
+	if p == UnknownMetricReject {
+		logger.Warn("event contains unknown metrics, dropping event", zap.Strings("unknown_metrics", unknown), zap.Object("event", ev))
+		return false
+	}
+
+	logger.Warn("event contains unknown metrics", zap.Strings("unknown_metrics", unknown), zap.Object("event", ev))
+	return true
+}
@@ -0,0 +1,65 @@
+package dmetering
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestEvent_UnknownMetrics(t *testing.T) {
+	ev := Event{Metrics: map[string]float64{MetricReadBytes: 10, "raed_bytes": 1, "custom": 2}}
+	assert.Equal(t, []string{"custom", "raed_bytes"}, ev.UnknownMetrics())
+
+	RegisterMetric(MetricDefinition{Name: "custom", Unit: MetricUnitCount, Kind: MetricKindGauge})
+	defer func() {
+		metricDefinitionsLock.Lock()
+		delete(metricDefinitions, "custom")
+		metricDefinitionsLock.Unlock()
+	}()
+
+	assert.Equal(t, []string{"raed_bytes"}, ev.UnknownMetrics())
+
+	pbev := ev.ToProto("eth-mainnet")
+	units := map[string]string{}
+	for _, metric := range pbev.Metrics {
+		units[metric.Key] = metric.Unit
+	}
+	assert.Equal(t, map[string]string{MetricReadBytes: "bytes", "custom": "count", "raed_bytes": ""}, units)
+}
+
+func TestUnknownMetricPolicy_Check(t *testing.T) {
+	known := Event{Endpoint: "sf.firehose.v2/Blocks", Metrics: map[string]float64{MetricReadBytes: 10}}
+	unknown := Event{Endpoint: "sf.firehose.v2/Blocks", Metrics: map[string]float64{"raed_bytes": 10}}
+
+	tests := []struct {
+		policy       string
+		event        Event
+		expectKeep   bool
+		expectWarned bool
+	}{
+		{"", unknown, true, false},
+		{"allow", unknown, true, false},
+		{"warn", known, true, false},
+		{"warn", unknown, true, true},
+		{"reject", known, true, false},
+		{"reject", unknown, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			policy, err := ParseUnknownMetricPolicy(test.policy)
+			require.NoError(t, err)
+
+			core, logs := observer.New(zapcore.WarnLevel)
+			assert.Equal(t, test.expectKeep, policy.Check(test.event, zap.New(core)))
+			assert.Equal(t, test.expectWarned, logs.Len() > 0)
+		})
+	}
+
+	_, err := ParseUnknownMetricPolicy("drop")
+	assert.Error(t, err)
+}
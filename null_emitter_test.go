@@ -0,0 +1,27 @@
+package dmetering_test
+
+import (
+	"testing"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNullEmitter_Conformance(t *testing.T) {
+	dmetering.RegisterNull()
+
+	dmeteringtest.RunEmitterConformance(t, func(t *testing.T) (dmetering.EventEmitter, func() []dmetering.Event) {
+		emitter, err := dmetering.New("null://", nil)
+		require.NoError(t, err)
+		return emitter, nil
+	})
+}
+
+func TestNew_UnknownPlugin(t *testing.T) {
+	dmetering.RegisterNull()
+
+	_, err := dmetering.New("unknown://", nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `no plugin named "unknown"`)
+}
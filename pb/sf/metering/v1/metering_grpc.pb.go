@@ -24,6 +24,9 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MeteringClient interface {
 	Emit(ctx context.Context, in *Events, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// GetUsage returns the usage aggregated by the collector, it's only available
+	// on collectors keeping an aggregated store.
+	GetUsage(ctx context.Context, in *UsageRequest, opts ...grpc.CallOption) (*UsageResponse, error)
 }
 
 type meteringClient struct {
@@ -43,11 +46,23 @@ func (c *meteringClient) Emit(ctx context.Context, in *Events, opts ...grpc.Call
 	return out, nil
 }
 
+func (c *meteringClient) GetUsage(ctx context.Context, in *UsageRequest, opts ...grpc.CallOption) (*UsageResponse, error) {
+	out := new(UsageResponse)
+	err := c.cc.Invoke(ctx, "/sf.metering.v1.Metering/GetUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MeteringServer is the server API for Metering service.
 // All implementations should embed UnimplementedMeteringServer
 // for forward compatibility
 type MeteringServer interface {
 	Emit(context.Context, *Events) (*emptypb.Empty, error)
+	// GetUsage returns the usage aggregated by the collector, it's only available
+	// on collectors keeping an aggregated store.
+	GetUsage(context.Context, *UsageRequest) (*UsageResponse, error)
 }
 
 // UnimplementedMeteringServer should be embedded to have forward compatible implementations.
@@ -57,6 +72,9 @@ type UnimplementedMeteringServer struct {
 func (UnimplementedMeteringServer) Emit(context.Context, *Events) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Emit not implemented")
 }
+func (UnimplementedMeteringServer) GetUsage(context.Context, *UsageRequest) (*UsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
 
 // UnsafeMeteringServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to MeteringServer will
@@ -87,6 +105,24 @@ func _Metering_Emit_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Metering_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeteringServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sf.metering.v1.Metering/GetUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeteringServer).GetUsage(ctx, req.(*UsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metering_ServiceDesc is the grpc.ServiceDesc for Metering service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -98,6 +134,10 @@ var Metering_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Emit",
 			Handler:    _Metering_Emit_Handler,
 		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _Metering_GetUsage_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "sf/metering/v1/metering.proto",
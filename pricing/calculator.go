@@ -0,0 +1,122 @@
+package pricing
+
+import (
+	"sort"
+	"sync"
+
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+)
+
+// LineItem is the cost of a user's usage of a metric on an endpoint of a
+// network.
+type LineItem struct {
+	UserID   string
+	Network  string
+	Endpoint string
+	Metric   string
+
+	Quantity float64
+	Cost     float64
+	Currency string
+}
+
+type usageKey struct {
+	userID string
+	rule   *Rule
+}
+
+type lineItemKey struct {
+	userID, network, endpoint, metric string
+}
+
+// Calculator prices usage added to it in order, tracking each user's cumulative
+// usage per rule so that free allowances and tiers apply across calls. It's
+// safe for concurrent use. Metrics no rule prices are ignored.
+type Calculator struct {
+	pricer *Pricer
+
+	lock  sync.Mutex
+	usage map[usageKey]float64
+	items map[lineItemKey]*LineItem
+}
+
+func (p *Pricer) NewCalculator() *Calculator {
+	return &Calculator{
+		pricer: p,
+		usage:  make(map[usageKey]float64),
+		items:  make(map[lineItemKey]*LineItem),
+	}
+}
+
+// Add prices quantity of metric used by userID and returns its cost, false
+// when no rule prices it.
+func (c *Calculator) Add(userID, network, endpoint, metric string, quantity float64) (float64, bool) {
+	rule, found := c.pricer.Rule(metric, network, endpoint)
+	if !found {
+		return 0, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := usageKey{userID: userID, rule: rule}
+	cost := rule.Cost(c.usage[key], quantity)
+	c.usage[key] += quantity
+
+	itemKey := lineItemKey{userID: userID, network: network, endpoint: endpoint, metric: metric}
+	item := c.items[itemKey]
+	if item == nil {
+		item = &LineItem{UserID: userID, Network: network, Endpoint: endpoint, Metric: metric, Currency: c.pricer.currency}
+		c.items[itemKey] = item
+	}
+	item.Quantity += quantity
+	item.Cost += cost
+
+	return cost, true
+}
+
+// AddEvent prices every metric of ev and returns their total cost.
+func (c *Calculator) AddEvent(ev *pbmetering.Event) (total float64) {
+	for _, metric := range ev.Metrics {
+		cost, _ := c.Add(ev.UserId, ev.Network, ev.Endpoint, metric.Key, metric.Value)
+		total += cost
+	}
+	return total
+}
+
+// AddUsageRecord prices every metric of an aggregated usage record, see the
+// GetUsage RPC, and returns their total cost. Usage must have been grouped by
+// every dimension rules and line items depend on for the costs to be accurate.
+func (c *Calculator) AddUsageRecord(record *pbmetering.UsageRecord) (total float64) {
+	for _, metric := range record.Metrics {
+		cost, _ := c.Add(record.UserId, record.Network, record.Endpoint, metric.Key, metric.Value)
+		total += cost
+	}
+	return total
+}
+
+// LineItems returns the cost of the usage added so far, sorted by user,
+// network, endpoint and metric.
+func (c *Calculator) LineItems() []LineItem {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	out := make([]LineItem, 0, len(c.items))
+	for _, item := range c.items {
+		out = append(out, *item)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		left, right := out[i], out[j]
+		if left.UserID != right.UserID {
+			return left.UserID < right.UserID
+		}
+		if left.Network != right.Network {
+			return left.Network < right.Network
+		}
+		if left.Endpoint != right.Endpoint {
+			return left.Endpoint < right.Endpoint
+		}
+		return left.Metric < right.Metric
+	})
+	return out
+}
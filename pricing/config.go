@@ -0,0 +1,118 @@
+package pricing
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the price of metrics. It is usually loaded from a YAML (or
+// JSON) file like:
+//
+//	currency: USD
+//	rules:
+//	  # Rules are evaluated in order, the first matching one prices the metric
+//	  - metric: read_bytes
+//	    network: sol-mainnet
+//	    per: 1073741824 # price of a GiB
+//	    price: 0.08
+//	  - metric: read_bytes
+//	    per: 1073741824
+//	    freeAllowance: 10737418240 # first 10 GiB of each user are free
+//	    tiers:
+//	      - upTo: 1099511627776 # up to 1 TiB
+//	        price: 0.05
+//	      - price: 0.02
+type Config struct {
+	// Currency of the prices, attached to costs.
+	Currency string `yaml:"currency" json:"currency"`
+	Rules    []Rule `yaml:"rules" json:"rules"`
+}
+
+// Rule prices a metric, optionally for a single network or endpoint, an empty
+// network or endpoint matching all of them.
+type Rule struct {
+	Metric   string `yaml:"metric" json:"metric"`
+	Network  string `yaml:"network" json:"network"`
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+
+	// Per is the quantity of the metric Price and tier prices are given for, 1
+	// when unset.
+	Per float64 `yaml:"per" json:"per"`
+	// Price is the flat price, ignored when Tiers are set.
+	Price float64 `yaml:"price" json:"price"`
+	// FreeAllowance is the quantity of each user's usage that is not charged.
+	FreeAllowance float64 `yaml:"freeAllowance" json:"freeAllowance"`
+	// Tiers price each user's usage by cumulative quantity, in increasing UpTo
+	// order. The last tier may leave UpTo unset to cover any quantity, usage past
+	// the last tier is charged at its price otherwise.
+	Tiers []Tier `yaml:"tiers" json:"tiers"`
+}
+
+type Tier struct {
+	// UpTo is the cumulative quantity, free allowance included, this tier
+	// ends at.
+	UpTo  float64 `yaml:"upTo" json:"upTo"`
+	Price float64 `yaml:"price" json:"price"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read pricing config: %w", err)
+	}
+
+	c := &Config{}
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("decode pricing config %q: %w", path, err)
+	}
+
+	return c, nil
+}
+
+type emitterConfig struct {
+	Config  *Config
+	Network string
+	Period  time.Duration
+	Emitter string
+}
+
+func newEmitterConfig(configURL string) (*emitterConfig, error) {
+	u, err := url.Parse(configURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse urls: %w", err)
+	}
+
+	vals := u.Query()
+	c := &emitterConfig{
+		Network: vals.Get("network"),
+		Emitter: vals.Get("emitter"),
+	}
+	if c.Emitter == "" {
+		return nil, fmt.Errorf("emitter not specified (as query param)")
+	}
+
+	if value := vals.Get("period"); value != "" {
+		if c.Period, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid period value %q: %w", value, err)
+		}
+		if c.Period <= 0 {
+			return nil, fmt.Errorf("period must be positive, got %s", c.Period)
+		}
+	}
+
+	path := vals.Get("config")
+	if path == "" {
+		return nil, fmt.Errorf("config not specified (as query param)")
+	}
+
+	c.Config, err = LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,53 @@
+package pricing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	expected := &Config{
+		Currency: "USD",
+		Rules: []Rule{
+			{Metric: "read_bytes", Network: "sol-mainnet", Per: 1024, Price: 0.08},
+			{Metric: "read_bytes", Endpoint: "sf.firehose.v2/Blocks", FreeAllowance: 10, Tiers: []Tier{{UpTo: 100, Price: 0.05}, {Price: 0.02}}},
+		},
+	}
+
+	yamlPath := filepath.Join(dir, "prices.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+currency: USD
+rules:
+  - metric: read_bytes
+    network: sol-mainnet
+    per: 1024
+    price: 0.08
+  - metric: read_bytes
+    endpoint: sf.firehose.v2/Blocks
+    freeAllowance: 10
+    tiers:
+      - upTo: 100
+        price: 0.05
+      - price: 0.02
+`), 0600))
+
+	jsonPath := filepath.Join(dir, "prices.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{
+  "currency": "USD",
+  "rules": [
+    {"metric": "read_bytes", "network": "sol-mainnet", "per": 1024, "price": 0.08},
+    {"metric": "read_bytes", "endpoint": "sf.firehose.v2/Blocks", "freeAllowance": 10, "tiers": [{"upTo": 100, "price": 0.05}, {"price": 0.02}]}
+  ]
+}`), 0600))
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		c, err := LoadConfig(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, c, path)
+	}
+}
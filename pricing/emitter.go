@@ -0,0 +1,167 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	"go.uber.org/zap"
+)
+
+// MetricCost is the metric holding the cost of an event's usage, in the
+// currency of its CurrencyLabel label.
+const MetricCost = "cost"
+
+// CurrencyLabel is the label added to priced events holding the currency of
+// their MetricCost.
+const CurrencyLabel = "cost_currency"
+
+func init() {
+	dmetering.RegisterMetric(dmetering.MetricDefinition{
+		Name:        MetricCost,
+		Unit:        dmetering.MetricUnitCurrency,
+		Kind:        dmetering.MetricKindCounter,
+		Description: "Cost of the usage of the event, in the currency of its cost_currency label",
+	})
+}
+
+func Register() {
+	dmetering.Register("pricing", func(config string, logger *zap.Logger) (dmetering.EventEmitter, error) {
+		c, err := newEmitterConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pricing config: %w", err)
+		}
+
+		pricer, err := NewPricer(c.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		next, err := dmetering.New(c.Emitter, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create priced emitter: %w", err)
+		}
+
+		return New(pricer, c.Network, c.Period, nil, next), nil
+	})
+}
+
+// DefaultBillingPeriod is the billing period of emitters created with a zero
+// one.
+const DefaultBillingPeriod = 30 * 24 * time.Hour
+
+// New returns an emitter adding the cost of each event's usage on network as
+// the MetricCost metric before forwarding it to next. Free allowances and tiers
+// apply to each user's usage emitted during the current billing period, periods
+// of length period being aligned on the zero time, costs attached inline are
+// therefore estimates, bill from stored usage with a Calculator. Events without
+// a timestamp are priced at the time of clk, the wall clock when nil.
+func New(pricer *Pricer, network string, period time.Duration, clk clock.Clock, next dmetering.EventEmitter) dmetering.EventEmitter {
+	if period <= 0 {
+		period = DefaultBillingPeriod
+	}
+
+	return &emitter{
+		pricer:  pricer,
+		network: network,
+		period:  period,
+		clock:   clock.OrReal(clk),
+		usage:   make(map[usageKey]float64),
+		next:    next,
+	}
+}
+
+type emitter struct {
+	pricer  *Pricer
+	network string
+	period  time.Duration
+	clock   clock.Clock
+	next    dmetering.EventEmitter
+
+	// usage is the cumulative usage of each user per rule since periodStart,
+	// it's reset when an event of a later period is emitted.
+	lock        sync.Mutex
+	periodStart time.Time
+	usage       map[usageKey]float64
+}
+
+func (e *emitter) Emit(ctx context.Context, ev dmetering.Event) {
+	at := ev.Timestamp
+	if at.IsZero() {
+		at = e.clock.Now()
+	}
+
+	priced := false
+	var cost float64
+	for metric, quantity := range ev.Metrics {
+		metricCost, found := e.add(at, ev.UserID, ev.Endpoint, metric, quantity)
+		priced = priced || found
+		cost += metricCost
+	}
+
+	if priced {
+		ev = withCost(ev, cost, e.pricer.currency)
+	}
+
+	e.next.Emit(ctx, ev)
+}
+
+// add prices quantity of metric used by userID at the given time, like
+// Calculator.Add but without keeping line items. Usage of a period earlier
+// than the current one is priced against the current period's usage.
+func (e *emitter) add(at time.Time, userID, endpoint, metric string, quantity float64) (float64, bool) {
+	rule, found := e.pricer.Rule(metric, e.network, endpoint)
+	if !found {
+		return 0, false
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if periodStart := at.Truncate(e.period); periodStart.After(e.periodStart) {
+		e.periodStart = periodStart
+		e.usage = make(map[usageKey]float64)
+	}
+
+	key := usageKey{userID: userID, rule: rule}
+	cost := rule.Cost(e.usage[key], quantity)
+	e.usage[key] += quantity
+
+	return cost, true
+}
+
+func (e *emitter) Shutdown(err error) {
+	e.next.Shutdown(err)
+}
+
+func (e *emitter) Stats() dmetering.Stats {
+	stats, _ := dmetering.EmitterStats(e.next)
+	return stats
+}
+
+func (e *emitter) Healthy() error {
+	return dmetering.EmitterHealthy(e.next)
+}
+
+func withCost(ev dmetering.Event, cost float64, currency string) dmetering.Event {
+	metrics := make(map[string]float64, len(ev.Metrics)+1)
+	for k, v := range ev.Metrics {
+		metrics[k] = v
+	}
+	metrics[MetricCost] = cost
+	ev.Metrics = metrics
+
+	if currency != "" {
+		labels := make(map[string]string, len(ev.Labels)+1)
+		for k, v := range ev.Labels {
+			labels[k] = v
+		}
+		labels[CurrencyLabel] = currency
+		ev.Labels = labels
+	}
+
+	return ev
+}
@@ -0,0 +1,95 @@
+package pricing
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestEmitter(t *testing.T) {
+	pricer, err := NewPricer(&Config{Currency: "USD", Rules: []Rule{
+		{Metric: dmetering.MetricReadBytes, Network: "eth-mainnet", Per: 10, Price: 1, FreeAllowance: 10},
+	}})
+	require.NoError(t, err)
+
+	recorder := dmeteringtest.NewRecorder()
+	e := New(pricer, "eth-mainnet", 0, nil, recorder)
+
+	metrics := map[string]float64{dmetering.MetricReadBytes: 30, dmetering.MetricMessageCount: 2}
+	e.Emit(context.Background(), dmetering.Event{UserID: "user.1", Endpoint: "sf.firehose.v2/Blocks", Metrics: metrics})
+	e.Emit(context.Background(), dmetering.Event{UserID: "user.1", Endpoint: "sf.firehose.v2/Blocks", Metrics: map[string]float64{dmetering.MetricMessageCount: 1}})
+
+	events := recorder.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, map[string]float64{dmetering.MetricReadBytes: 30, dmetering.MetricMessageCount: 2, MetricCost: 2}, events[0].Metrics)
+	assert.Equal(t, map[string]string{CurrencyLabel: "USD"}, events[0].Labels)
+	assert.NotContains(t, metrics, MetricCost, "emitted event must not be modified")
+	assert.Equal(t, map[string]float64{dmetering.MetricMessageCount: 1}, events[1].Metrics, "events without priced metrics are forwarded as is")
+}
+
+func TestEmitter_BillingPeriod(t *testing.T) {
+	pricer, err := NewPricer(&Config{Rules: []Rule{
+		{Metric: dmetering.MetricReadBytes, Price: 1, FreeAllowance: 10},
+	}})
+	require.NoError(t, err)
+
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	recorder := dmeteringtest.NewRecorder()
+	e := New(pricer, "eth-mainnet", 24*time.Hour, clock.NewFake(day.Add(30*time.Hour)), recorder)
+	emit := func(at time.Time, readBytes float64) {
+		e.Emit(context.Background(), dmetering.Event{UserID: "user.1", Endpoint: "sf.firehose.v2/Blocks", Timestamp: at, Metrics: map[string]float64{dmetering.MetricReadBytes: readBytes}})
+	}
+
+	emit(day.Add(time.Hour), 8)
+	emit(day.Add(23*time.Hour), 8)
+	emit(day.Add(25*time.Hour), 8)
+	emit(day.Add(26*time.Hour), 8)
+	emit(day.Add(23*time.Hour), 8)
+	emit(time.Time{}, 8)
+
+	var costs []float64
+	for _, ev := range recorder.Events() {
+		costs = append(costs, ev.Metrics[MetricCost])
+	}
+	assert.Equal(t, []float64{0, 6, 0, 6, 8, 8}, costs, "free allowance is reset every period, late events are priced in the current one and events without timestamp at the clock's time")
+	assert.Len(t, e.(*emitter).usage, 1)
+}
+
+func TestRegister(t *testing.T) {
+	dmeteringtest.Register()
+	Register()
+
+	path := filepath.Join(t.TempDir(), "prices.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+currency: USD
+rules:
+  - metric: read_bytes
+    price: 0.5
+`), 0600))
+
+	_, err := dmetering.New("pricing://?emitter=memory://pricing", zap.NewNop())
+	assert.EqualError(t, err, "invalid pricing config: config not specified (as query param)")
+
+	_, err = dmetering.New("pricing://?period=-1h&config="+url.QueryEscape(path)+"&emitter=memory://pricing", zap.NewNop())
+	assert.EqualError(t, err, "invalid pricing config: period must be positive, got -1h0m0s")
+
+	e, err := dmetering.New("pricing://?network=eth-mainnet&config="+url.QueryEscape(path)+"&emitter="+url.QueryEscape("memory://pricing"), zap.NewNop())
+	require.NoError(t, err)
+
+	e.Emit(context.Background(), dmetering.Event{Endpoint: "sf.firehose.v2/Blocks", Metrics: map[string]float64{dmetering.MetricReadBytes: 4}})
+	e.Shutdown(nil)
+
+	events := dmeteringtest.NamedRecorder("pricing").Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, float64(2), events[0].Metrics[MetricCost])
+}
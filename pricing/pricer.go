@@ -0,0 +1,123 @@
+// Package pricing converts metered usage into costs according to rules per
+// metric, network and endpoint, with tiers and free allowances.
+package pricing
+
+import (
+	"fmt"
+	"math"
+)
+
+// Pricer finds the rule pricing a metric and computes costs from it. It is
+// stateless, see Calculator to price successive usage of the same users.
+type Pricer struct {
+	currency string
+	rules    []*Rule
+}
+
+func NewPricer(config *Config) (*Pricer, error) {
+	p := &Pricer{currency: config.Currency}
+
+	for i := range config.Rules {
+		rule := config.Rules[i]
+		if err := validateRule(&rule); err != nil {
+			return nil, fmt.Errorf("invalid rule #%d: %w", i, err)
+		}
+		p.rules = append(p.rules, &rule)
+	}
+
+	return p, nil
+}
+
+func validateRule(rule *Rule) error {
+	if rule.Metric == "" {
+		return fmt.Errorf("no metric specified")
+	}
+
+	if rule.Per < 0 {
+		return fmt.Errorf("per must be positive, got %g", rule.Per)
+	}
+	if rule.Per == 0 {
+		rule.Per = 1
+	}
+
+	if rule.Price < 0 || rule.FreeAllowance < 0 {
+		return fmt.Errorf("price and free allowance must be positive")
+	}
+
+	var previous float64
+	for i, tier := range rule.Tiers {
+		if tier.Price < 0 {
+			return fmt.Errorf("tier #%d: price must be positive, got %g", i, tier.Price)
+		}
+
+		last := i == len(rule.Tiers)-1
+		if tier.UpTo == 0 && last {
+			continue
+		}
+		if tier.UpTo <= previous {
+			return fmt.Errorf("tier #%d: upTo must be greater than the previous tier's, got %g", i, tier.UpTo)
+		}
+		previous = tier.UpTo
+	}
+
+	return nil
+}
+
+// Currency returns the currency of the configured prices.
+func (p *Pricer) Currency() string {
+	return p.currency
+}
+
+// Rule returns the first rule matching metric on network and endpoint.
+func (p *Pricer) Rule(metric, network, endpoint string) (*Rule, bool) {
+	for _, rule := range p.rules {
+		if rule.Metric != metric {
+			continue
+		}
+		if rule.Network != "" && rule.Network != network {
+			continue
+		}
+		if rule.Endpoint != "" && rule.Endpoint != endpoint {
+			continue
+		}
+		return rule, true
+	}
+	return nil, false
+}
+
+// Cost returns the cost of quantity for a user whose usage priced by this rule
+// was already previous, free allowance and tiers applying to the cumulative
+// usage.
+func (r *Rule) Cost(previous, quantity float64) float64 {
+	if quantity <= 0 {
+		return 0
+	}
+
+	from := math.Max(previous, r.FreeAllowance)
+	to := previous + quantity
+	if to <= from {
+		return 0
+	}
+
+	if len(r.Tiers) == 0 {
+		return (to - from) * r.Price / r.Per
+	}
+
+	var cost, tierStart float64
+	for i, tier := range r.Tiers {
+		tierEnd := tier.UpTo
+		if tierEnd == 0 || i == len(r.Tiers)-1 {
+			tierEnd = math.Inf(1)
+		}
+
+		if overlap := math.Min(to, tierEnd) - math.Max(from, tierStart); overlap > 0 {
+			cost += overlap * tier.Price / r.Per
+		}
+
+		if to <= tierEnd {
+			break
+		}
+		tierStart = tierEnd
+	}
+	return cost
+}
@@ -0,0 +1,114 @@
+package pricing
+
+import (
+	"testing"
+
+	pbmetering "github.com/streamingfast/dmetering/pb/sf/metering/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRule_Cost(t *testing.T) {
+	tiered := Rule{Metric: "read_bytes", Per: 10, Tiers: []Tier{{UpTo: 100, Price: 2}, {UpTo: 200, Price: 1}, {Price: 0.5}}}
+
+	tests := []struct {
+		name     string
+		rule     Rule
+		previous float64
+		quantity float64
+		expect   float64
+	}{
+		{"flat", Rule{Per: 1, Price: 0.5}, 0, 10, 5},
+		{"flat per unit", Rule{Per: 10, Price: 0.5}, 0, 100, 5},
+		{"free allowance not reached", Rule{Per: 1, Price: 1, FreeAllowance: 100}, 50, 40, 0},
+		{"free allowance crossed", Rule{Per: 1, Price: 1, FreeAllowance: 100}, 50, 60, 10},
+		{"free allowance exhausted", Rule{Per: 1, Price: 1, FreeAllowance: 100}, 150, 10, 10},
+		{"first tier", tiered, 0, 50, 10},
+		{"across tiers", tiered, 50, 200, 10 + 10 + 2.5},
+		{"last tier unbounded", tiered, 1000, 100, 5},
+		{"tiers with free allowance", Rule{Per: 1, FreeAllowance: 50, Tiers: []Tier{{UpTo: 100, Price: 1}, {UpTo: 200, Price: 0.5}}}, 0, 300, 50 + 50 + 50},
+		{"zero quantity", tiered, 10, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.InDelta(t, test.expect, test.rule.Cost(test.previous, test.quantity), 1e-9)
+		})
+	}
+}
+
+func TestNewPricer_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		rule        Rule
+		expectError string
+	}{
+		{"no metric", Rule{Price: 1}, "invalid rule #0: no metric specified"},
+		{"negative per", Rule{Metric: "m", Per: -1}, "invalid rule #0: per must be positive, got -1"},
+		{"negative price", Rule{Metric: "m", Price: -1}, "invalid rule #0: price and free allowance must be positive"},
+		{"unordered tiers", Rule{Metric: "m", Tiers: []Tier{{UpTo: 10}, {UpTo: 5}, {}}}, "invalid rule #0: tier #1: upTo must be greater than the previous tier's, got 5"},
+		{"unbounded tier not last", Rule{Metric: "m", Tiers: []Tier{{Price: 1}, {UpTo: 5}}}, "invalid rule #0: tier #0: upTo must be greater than the previous tier's, got 0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewPricer(&Config{Rules: []Rule{test.rule}})
+			assert.EqualError(t, err, test.expectError)
+		})
+	}
+}
+
+func TestPricer_Rule(t *testing.T) {
+	pricer, err := NewPricer(&Config{Rules: []Rule{
+		{Metric: "read_bytes", Network: "sol-mainnet", Price: 3},
+		{Metric: "read_bytes", Endpoint: "sf.firehose.v2/Fetch", Price: 2},
+		{Metric: "read_bytes", Price: 1},
+	}})
+	require.NoError(t, err)
+
+	price := func(metric, network, endpoint string) float64 {
+		rule, found := pricer.Rule(metric, network, endpoint)
+		if !found {
+			return -1
+		}
+		return rule.Price
+	}
+
+	assert.Equal(t, float64(3), price("read_bytes", "sol-mainnet", "sf.firehose.v2/Fetch"), "first matching rule wins")
+	assert.Equal(t, float64(2), price("read_bytes", "eth-mainnet", "sf.firehose.v2/Fetch"))
+	assert.Equal(t, float64(1), price("read_bytes", "eth-mainnet", "sf.firehose.v2/Blocks"))
+	assert.Equal(t, float64(-1), price("message_count", "eth-mainnet", "sf.firehose.v2/Blocks"))
+}
+
+func TestCalculator(t *testing.T) {
+	pricer, err := NewPricer(&Config{Currency: "USD", Rules: []Rule{
+		{Metric: "read_bytes", Per: 1, FreeAllowance: 100, Tiers: []Tier{{UpTo: 200, Price: 1}, {Price: 0.5}}},
+	}})
+	require.NoError(t, err)
+
+	event := func(user, network string, readBytes float64) *pbmetering.Event {
+		return &pbmetering.Event{
+			UserId:   user,
+			Network:  network,
+			Endpoint: "sf.firehose.v2/Blocks",
+			Metrics:  []*pbmetering.Metric{{Key: "read_bytes", Value: readBytes}, {Key: "message_count", Value: 1}},
+		}
+	}
+
+	calculator := pricer.NewCalculator()
+	assert.Equal(t, float64(0), calculator.AddEvent(event("user.1", "eth-mainnet", 80)))
+	assert.Equal(t, float64(20), calculator.AddEvent(event("user.1", "sol-mainnet", 40)), "allowance is shared across networks of a rule")
+	assert.Equal(t, float64(80+50), calculator.AddEvent(event("user.1", "eth-mainnet", 180)))
+	assert.Equal(t, float64(10), calculator.AddUsageRecord(&pbmetering.UsageRecord{
+		UserId:   "user.2",
+		Network:  "eth-mainnet",
+		Endpoint: "sf.firehose.v2/Blocks",
+		Metrics:  []*pbmetering.Metric{{Key: "read_bytes", Value: 110}},
+	}), "allowance is per user")
+
+	assert.Equal(t, []LineItem{
+		{UserID: "user.1", Network: "eth-mainnet", Endpoint: "sf.firehose.v2/Blocks", Metric: "read_bytes", Quantity: 260, Cost: 130, Currency: "USD"},
+		{UserID: "user.1", Network: "sol-mainnet", Endpoint: "sf.firehose.v2/Blocks", Metric: "read_bytes", Quantity: 40, Cost: 20, Currency: "USD"},
+		{UserID: "user.2", Network: "eth-mainnet", Endpoint: "sf.firehose.v2/Blocks", Metric: "read_bytes", Quantity: 110, Cost: 10, Currency: "USD"},
+	}, calculator.LineItems())
+}
@@ -0,0 +1,99 @@
+package privacy
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	Policy Policy
+	// RedactLogs installs the policy as a dmetering log redactor, until the
+	// emitter is shut down, so that events logged by any emitter are anonymized
+	// as well.
+	RedactLogs bool
+	// Emitter is the DSN of the emitter receiving the anonymized events.
+	Emitter string
+}
+
+func newConfig(configURL string) (*Config, error) {
+	c := &Config{
+		Policy: Policy{
+			IPv4PrefixLength: 24,
+			IPv6PrefixLength: 48,
+		},
+		RedactLogs: true,
+	}
+
+	u, err := url.Parse(configURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse urls: %w", err)
+	}
+
+	vals := u.Query()
+	c.Emitter = vals.Get("emitter")
+	if c.Emitter == "" {
+		return nil, fmt.Errorf("emitter not specified (as query param)")
+	}
+
+	c.Policy.IPAddress = IPAction(keepAsZero(vals.Get("ip")))
+	c.Policy.UserID = Action(keepAsZero(vals.Get("userID")))
+	c.Policy.ApiKeyID = Action(keepAsZero(vals.Get("apiKeyID")))
+
+	if value := vals.Get("ipv4Prefix"); value != "" {
+		if c.Policy.IPv4PrefixLength, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid ipv4Prefix value %q: %w", value, err)
+		}
+	}
+
+	if value := vals.Get("ipv6Prefix"); value != "" {
+		if c.Policy.IPv6PrefixLength, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid ipv6Prefix value %q: %w", value, err)
+		}
+	}
+
+	switch {
+	case vals.Get("secretEnv") != "":
+		c.Policy.Secret = []byte(os.Getenv(vals.Get("secretEnv")))
+		if len(c.Policy.Secret) == 0 {
+			return nil, fmt.Errorf("environment variable %q referenced by secretEnv is empty", vals.Get("secretEnv"))
+		}
+	case vals.Get("secretFile") != "":
+		content, err := os.ReadFile(vals.Get("secretFile"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secretFile value %q: %w", vals.Get("secretFile"), err)
+		}
+		// Secret files usually end with a newline, which must not change the key
+		c.Policy.Secret = bytes.TrimSpace(content)
+		if len(c.Policy.Secret) == 0 {
+			return nil, fmt.Errorf("file %q referenced by secretFile is empty", vals.Get("secretFile"))
+		}
+	}
+
+	if value := vals.Get("keyRotation"); value != "" {
+		if c.Policy.KeyRotation, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid keyRotation value %q: %w", value, err)
+		}
+	}
+
+	if value := vals.Get("redactLogs"); value != "" {
+		c.RedactLogs = value == "true"
+	}
+
+	if err := c.Policy.validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// keepAsZero maps the explicit "keep" value to the zero value of actions.
+func keepAsZero(in string) string {
+	if in == "keep" {
+		return ""
+	}
+	return in
+}
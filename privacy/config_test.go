@@ -0,0 +1,93 @@
+package privacy
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_new(t *testing.T) {
+	t.Setenv("DMETERING_TEST_PRIVACY_SECRET", "s3cr3t")
+
+	tests := []struct {
+		dsn         string
+		expect      *Config
+		expectError bool
+	}{
+		{
+			dsn: "privacy://?ip=truncate&userID=hash&apiKeyID=hmac&secretEnv=DMETERING_TEST_PRIVACY_SECRET&keyRotation=24h&emitter=logger://",
+			expect: &Config{
+				Policy: Policy{
+					IPAddress:        IPActionTruncate,
+					IPv4PrefixLength: 24,
+					IPv6PrefixLength: 48,
+					UserID:           ActionHash,
+					ApiKeyID:         ActionHMAC,
+					Secret:           []byte("s3cr3t"),
+					KeyRotation:      24 * time.Hour,
+				},
+				RedactLogs: true,
+				Emitter:    "logger://",
+			},
+		},
+		{
+			dsn: "privacy://?ip=truncate&ipv4Prefix=16&ipv6Prefix=32&apiKeyID=drop&redactLogs=false&emitter=logger://",
+			expect: &Config{
+				Policy: Policy{
+					IPAddress:        IPActionTruncate,
+					IPv4PrefixLength: 16,
+					IPv6PrefixLength: 32,
+					ApiKeyID:         ActionDrop,
+				},
+				Emitter: "logger://",
+			},
+		},
+		{
+			dsn: "privacy://?ip=keep&userID=keep&apiKeyID=hash&emitter=logger://",
+			expect: &Config{
+				Policy: Policy{
+					IPv4PrefixLength: 24,
+					IPv6PrefixLength: 48,
+					ApiKeyID:         ActionHash,
+				},
+				RedactLogs: true,
+				Emitter:    "logger://",
+			},
+		},
+		{dsn: "privacy://?ip=truncate", expectError: true},
+		{dsn: "privacy://?apiKeyID=hmac&emitter=logger://", expectError: true},
+		{dsn: "privacy://?userID=encrypt&emitter=logger://", expectError: true},
+		{dsn: "privacy://?ip=truncate&ipv4Prefix=33&emitter=logger://", expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dsn, func(t *testing.T) {
+			c, err := newConfig(test.dsn)
+			if test.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.expect, c)
+			}
+		})
+	}
+}
+
+func TestConfig_secretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("s3cr3t\n"), 0600))
+
+	c, err := newConfig("privacy://?userID=hmac&secretFile=" + url.QueryEscape(path) + "&emitter=logger://")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("s3cr3t"), c.Policy.Secret, "trailing newline is not part of the secret")
+
+	require.NoError(t, os.WriteFile(path, []byte("\n"), 0600))
+	_, err = newConfig("privacy://?userID=hmac&secretFile=" + url.QueryEscape(path) + "&emitter=logger://")
+	assert.EqualError(t, err, fmt.Sprintf("file %q referenced by secretFile is empty", path))
+}
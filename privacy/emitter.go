@@ -0,0 +1,85 @@
+package privacy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streamingfast/dmetering"
+	"go.uber.org/zap"
+)
+
+func Register() {
+	dmetering.Register("privacy", func(config string, logger *zap.Logger) (dmetering.EventEmitter, error) {
+		c, err := newConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid privacy config: %w", err)
+		}
+
+		// Events reaching the anonymized emitter are already anonymized, the
+		// events it logs must not go through log redactors again
+		next, err := dmetering.New(c.Emitter, logger.WithOptions(zap.WrapCore(newAnonymizedCore)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create anonymized emitter: %w", err)
+		}
+
+		e, err := newEmitter(&c.Policy, next)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.RedactLogs {
+			e.removeLogRedactor = dmetering.AddLogRedactor(c.Policy.Apply)
+		}
+
+		return e, nil
+	})
+}
+
+// New returns an emitter applying policy to every event before forwarding it
+// to next.
+func New(policy *Policy, next dmetering.EventEmitter) (dmetering.EventEmitter, error) {
+	e, err := newEmitter(policy, next)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func newEmitter(policy *Policy, next dmetering.EventEmitter) (*emitter, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	return &emitter{
+		policy: policy,
+		next:   next,
+	}, nil
+}
+
+type emitter struct {
+	policy *Policy
+	next   dmetering.EventEmitter
+
+	removeLogRedactor func()
+}
+
+func (e *emitter) Emit(ctx context.Context, ev dmetering.Event) {
+	e.next.Emit(ctx, e.policy.Apply(ev))
+}
+
+func (e *emitter) Shutdown(err error) {
+	e.next.Shutdown(err)
+
+	if e.removeLogRedactor != nil {
+		e.removeLogRedactor()
+	}
+}
+
+func (e *emitter) Stats() dmetering.Stats {
+	stats, _ := dmetering.EmitterStats(e.next)
+	return stats
+}
+
+func (e *emitter) Healthy() error {
+	return dmetering.EmitterHealthy(e.next)
+}
@@ -0,0 +1,56 @@
+package privacy
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRegister_LogRedaction(t *testing.T) {
+	logger.Register()
+	Register()
+
+	logged := func(ev dmetering.Event) map[string]interface{} {
+		enc := zapcore.NewMapObjectEncoder()
+		require.NoError(t, ev.MarshalLogObject(enc))
+		return enc.Fields
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	hashing, err := dmetering.New("privacy://?userID=hash&emitter="+url.QueryEscape("logger://"), zap.New(core))
+	require.NoError(t, err)
+	dropping, err := dmetering.New("privacy://?ip=drop&emitter="+url.QueryEscape("logger://"), zap.NewNop())
+	require.NoError(t, err)
+
+	ev := dmetering.Event{UserID: "user-1", IpAddress: "203.0.113.195", Endpoint: "sf.firehose.v2/Blocks"}
+	hashing.Emit(context.Background(), ev)
+
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	enc := zapcore.NewMapObjectEncoder()
+	entries[0].Context[0].AddTo(enc)
+	fields := enc.Fields["event"].(map[string]interface{})
+	assert.Equal(t, (&Policy{UserID: ActionHash}).Apply(ev).UserID, fields["user_id"], "events logged by the anonymized emitter are not anonymized again")
+	assert.Equal(t, "203.0.113.195", fields["ip_address"], "nor go through the redactors of other privacy emitters")
+	assert.Equal(t, "sf.firehose.v2/Blocks", fields["endpoint"])
+
+	fields = logged(ev)
+	assert.Equal(t, (&Policy{UserID: ActionHash}).Apply(ev).UserID, fields["user_id"], "policies of all privacy emitters apply")
+	assert.NotContains(t, fields, "ip_address")
+
+	hashing.Shutdown(nil)
+	fields = logged(ev)
+	assert.Equal(t, "user-1", fields["user_id"], "shutdown removes the emitter's redactor")
+	assert.NotContains(t, fields, "ip_address")
+
+	dropping.Shutdown(nil)
+	assert.Equal(t, "203.0.113.195", logged(ev)["ip_address"])
+}
@@ -0,0 +1,72 @@
+package privacy
+
+import (
+	"github.com/streamingfast/dmetering"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// anonymizedCore wraps the core of the logger handed to the emitters receiving
+// anonymized events, events they log are encoded as anonymizedEvent.
+type anonymizedCore struct {
+	zapcore.Core
+}
+
+func newAnonymizedCore(core zapcore.Core) zapcore.Core {
+	return &anonymizedCore{Core: core}
+}
+
+func (c *anonymizedCore) With(fields []zapcore.Field) zapcore.Core {
+	return &anonymizedCore{Core: c.Core.With(anonymizedFields(fields))}
+}
+
+func (c *anonymizedCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *anonymizedCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(entry, anonymizedFields(fields))
+}
+
+func anonymizedFields(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, field := range fields {
+		ev, ok := field.Interface.(dmetering.Event)
+		if !ok || field.Type != zapcore.ObjectMarshalerType {
+			continue
+		}
+
+		if out == nil {
+			out = append([]zapcore.Field(nil), fields...)
+		}
+		out[i] = zap.Object(field.Key, anonymizedEvent(ev))
+	}
+
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// anonymizedEvent is an event that went through a privacy policy. Its
+// identifiers are encoded as is, log redactors only seeing the event without
+// them.
+type anonymizedEvent dmetering.Event
+
+func (ev anonymizedEvent) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if ev.UserID != "" {
+		enc.AddString("user_id", ev.UserID)
+	}
+	if ev.ApiKeyID != "" {
+		enc.AddString("api_key_id", ev.ApiKeyID)
+	}
+	if ev.IpAddress != "" {
+		enc.AddString("ip_address", ev.IpAddress)
+	}
+
+	ev.UserID, ev.ApiKeyID, ev.IpAddress = "", "", ""
+	return dmetering.Event(ev).MarshalLogObject(enc)
+}
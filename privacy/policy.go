@@ -0,0 +1,165 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+)
+
+// Action defines how an identifier is transformed before leaving the process.
+type Action string
+
+const (
+	// ActionKeep leaves the value untouched, it's the zero value.
+	ActionKeep Action = ""
+	// ActionHash replaces the value by its hex encoded SHA-256 digest.
+	ActionHash Action = "hash"
+	// ActionHMAC replaces the value by its hex encoded HMAC-SHA256 under the
+	// policy's key, which cannot be reversed by brute force without the key.
+	ActionHMAC Action = "hmac"
+	// ActionDrop removes the value.
+	ActionDrop Action = "drop"
+)
+
+// IPAction defines how IP addresses are transformed.
+type IPAction string
+
+const (
+	// IPActionKeep leaves the address untouched, it's the zero value.
+	IPActionKeep IPAction = ""
+	// IPActionTruncate zeroes the host part of the address according to
+	// IPv4PrefixLength and IPv6PrefixLength.
+	IPActionTruncate IPAction = "truncate"
+	// IPActionDrop removes the address.
+	IPActionDrop IPAction = "drop"
+)
+
+// Policy describes the anonymization applied to events. Its zero value leaves
+// events untouched.
+type Policy struct {
+	IPAddress        IPAction
+	IPv4PrefixLength int
+	IPv6PrefixLength int
+
+	UserID   Action
+	ApiKeyID Action
+
+	// Secret is the key used by ActionHMAC.
+	Secret []byte
+	// KeyRotation, when non-zero, derives a new HMAC key from Secret for every
+	// period of that length based on the event's timestamp. Pseudonyms of a
+	// same identifier then differ across periods, limiting how long they can be
+	// linked together.
+	KeyRotation time.Duration
+
+	// Clock decides the key rotation period of events without a timestamp, it
+	// defaults to the wall clock.
+	Clock clock.Clock
+}
+
+func (p *Policy) validate() error {
+	for _, action := range []Action{p.UserID, p.ApiKeyID} {
+		switch action {
+		case ActionKeep, ActionHash, ActionDrop:
+		case ActionHMAC:
+			if len(p.Secret) == 0 {
+				return fmt.Errorf("hmac action requires a secret")
+			}
+		default:
+			return fmt.Errorf("unknown action %q", action)
+		}
+	}
+
+	switch p.IPAddress {
+	case IPActionKeep, IPActionDrop:
+	case IPActionTruncate:
+		if p.IPv4PrefixLength < 0 || p.IPv4PrefixLength > 32 {
+			return fmt.Errorf("IPv4 prefix length must be between 0 and 32, got %d", p.IPv4PrefixLength)
+		}
+		if p.IPv6PrefixLength < 0 || p.IPv6PrefixLength > 128 {
+			return fmt.Errorf("IPv6 prefix length must be between 0 and 128, got %d", p.IPv6PrefixLength)
+		}
+	default:
+		return fmt.Errorf("unknown IP action %q", p.IPAddress)
+	}
+
+	return nil
+}
+
+// Apply returns ev with the policy applied to its identifiers.
+func (p *Policy) Apply(ev dmetering.Event) dmetering.Event {
+	ev.UserID = p.transform(p.UserID, ev.UserID, ev.Timestamp)
+	ev.ApiKeyID = p.transform(p.ApiKeyID, ev.ApiKeyID, ev.Timestamp)
+
+	switch p.IPAddress {
+	case IPActionDrop:
+		ev.IpAddress = ""
+	case IPActionTruncate:
+		ev.IpAddress = truncateIP(ev.IpAddress, p.IPv4PrefixLength, p.IPv6PrefixLength)
+	}
+
+	return ev
+}
+
+func (p *Policy) transform(action Action, value string, at time.Time) string {
+	if value == "" {
+		return ""
+	}
+
+	switch action {
+	case ActionHash:
+		digest := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(digest[:])
+	case ActionHMAC:
+		mac := hmac.New(sha256.New, p.key(at))
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	case ActionDrop:
+		return ""
+	}
+
+	return value
+}
+
+func (p *Policy) key(at time.Time) []byte {
+	if p.KeyRotation <= 0 {
+		return p.Secret
+	}
+
+	if at.IsZero() {
+		at = clock.OrReal(p.Clock).Now()
+	}
+
+	period := make([]byte, 8)
+	binary.BigEndian.PutUint64(period, uint64(at.UnixNano()/int64(p.KeyRotation)))
+
+	mac := hmac.New(sha256.New, p.Secret)
+	mac.Write(period)
+	return mac.Sum(nil)
+}
+
+// truncateIP keeps only the network prefix of ip, an address that cannot be
+// parsed is dropped rather than sent as is.
+func truncateIP(ip string, ipv4PrefixLength, ipv6PrefixLength int) string {
+	if ip == "" {
+		return ""
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(ipv4PrefixLength, 32)).String()
+	}
+
+	return parsed.Mask(net.CIDRMask(ipv6PrefixLength, 128)).String()
+}
@@ -0,0 +1,95 @@
+package privacy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestTruncateIP(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect string
+	}{
+		{"203.0.113.195", "203.0.113.0"},
+		{"::ffff:203.0.113.195", "203.0.113.0"},
+		{"2001:db8:85a3:8d3:1319:8a2e:370:7348", "2001:db8:85a3::"},
+		{"not-an-ip", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			assert.Equal(t, test.expect, truncateIP(test.in, 24, 48))
+		})
+	}
+}
+
+func TestPolicy_Apply(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ev := dmetering.Event{
+		Endpoint:  "sf.firehose.v2/Blocks",
+		UserID:    "user-1",
+		ApiKeyID:  "key-1",
+		IpAddress: "203.0.113.195",
+		Timestamp: at,
+	}
+
+	policy := &Policy{
+		IPAddress:        IPActionTruncate,
+		IPv4PrefixLength: 24,
+		IPv6PrefixLength: 48,
+		UserID:           ActionHash,
+		ApiKeyID:         ActionHMAC,
+		Secret:           []byte("secret"),
+	}
+	require.NoError(t, policy.validate())
+
+	out := policy.Apply(ev)
+	assert.Equal(t, "203.0.113.0", out.IpAddress)
+	assert.Len(t, out.UserID, 64)
+	assert.NotEqual(t, ev.UserID, out.UserID)
+	assert.Len(t, out.ApiKeyID, 64)
+	assert.Equal(t, out, policy.Apply(ev), "pseudonyms must be stable")
+
+	other := *policy
+	other.Secret = []byte("other")
+	assert.NotEqual(t, out.ApiKeyID, other.Apply(ev).ApiKeyID, "hmac must depend on the secret")
+
+	dropping := &Policy{IPAddress: IPActionDrop, UserID: ActionDrop, ApiKeyID: ActionDrop}
+	assert.Equal(t, dmetering.Event{Endpoint: "sf.firehose.v2/Blocks", Timestamp: at}, dropping.Apply(ev))
+
+	assert.Equal(t, ev, (&Policy{}).Apply(ev), "zero policy must keep events untouched")
+}
+
+func TestPolicy_KeyRotation(t *testing.T) {
+	policy := &Policy{ApiKeyID: ActionHMAC, Secret: []byte("secret"), KeyRotation: 24 * time.Hour}
+
+	at := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	morning := policy.Apply(dmetering.Event{ApiKeyID: "key-1", Timestamp: at})
+	evening := policy.Apply(dmetering.Event{ApiKeyID: "key-1", Timestamp: at.Add(20 * time.Hour)})
+	nextDay := policy.Apply(dmetering.Event{ApiKeyID: "key-1", Timestamp: at.Add(24 * time.Hour)})
+
+	assert.Equal(t, morning.ApiKeyID, evening.ApiKeyID)
+	assert.NotEqual(t, morning.ApiKeyID, nextDay.ApiKeyID)
+
+	policy.Clock = clock.NewFake(at.Add(20 * time.Hour))
+	assert.Equal(t, morning.ApiKeyID, policy.Apply(dmetering.Event{ApiKeyID: "key-1"}).ApiKeyID, "events without timestamp use the clock's period")
+}
+
+func TestPolicy_LogRedaction(t *testing.T) {
+	policy := &Policy{IPAddress: IPActionTruncate, IPv4PrefixLength: 16, UserID: ActionDrop}
+	remove := dmetering.AddLogRedactor(policy.Apply)
+	defer remove()
+
+	enc := zapcore.NewMapObjectEncoder()
+	require.NoError(t, dmetering.Event{UserID: "user-1", IpAddress: "203.0.113.195"}.MarshalLogObject(enc))
+
+	assert.NotContains(t, enc.Fields, "user_id")
+	assert.Equal(t, "203.0.0.0", enc.Fields["ip_address"])
+}
@@ -1,6 +1,8 @@
 package dmetering
 
 import (
+	"sort"
+
 	"go.uber.org/zap"
 )
 
@@ -11,3 +13,13 @@ type FactoryFunc func(config string, logger *zap.Logger) (EventEmitter, error)
 func Register(name string, factory FactoryFunc) {
 	registry[name] = factory
 }
+
+// RegisteredPlugins returns the sorted schemes of all registered plugins.
+func RegisteredPlugins() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
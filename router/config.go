@@ -0,0 +1,75 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the child emitters and the rules routing events to them. It
+// is usually loaded from a YAML (or JSON) file like:
+//
+//	emitters:
+//	  billing: grpc://collector:9010?network=eth-mainnet
+//	  logs: logger://
+//	rules:
+//	  - endpoint: "sf.substreams.*"
+//	    emitters: [billing, logs]
+//	  - endpoint: "sf.firehose.v2/Fetch"
+//	    emitters: [logs]
+//	default: [billing]
+type Config struct {
+	// Emitters maps a name to the DSN of a child emitter, built through dmetering.New.
+	Emitters map[string]string `yaml:"emitters" json:"emitters"`
+	// Rules are evaluated in order, an event is sent to the emitters of the first
+	// rule it matches.
+	Rules []Rule `yaml:"rules" json:"rules"`
+	// Default lists the emitters receiving events that match no rule, they are
+	// dropped when it's empty.
+	Default []string `yaml:"default" json:"default"`
+}
+
+// Rule matches events on their endpoint, meta, labels and metric keys, a rule
+// with no criteria matches every event. Patterns are globs where `*` matches
+// any sequence of characters and `?` a single one, or regular expressions when
+// prefixed with `regex:`.
+type Rule struct {
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	Meta     string `yaml:"meta" json:"meta"`
+	// Labels must all be present on the event and match their pattern.
+	Labels map[string]string `yaml:"labels" json:"labels"`
+	// Metrics matches if at least one of the event's metric keys matches one of the patterns.
+	Metrics []string `yaml:"metrics" json:"metrics"`
+
+	Emitters []string `yaml:"emitters" json:"emitters"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read router config: %w", err)
+	}
+
+	c := &Config{}
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("decode router config %q: %w", path, err)
+	}
+
+	return c, nil
+}
+
+func newConfig(configURL string) (*Config, error) {
+	u, err := url.Parse(configURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse urls: %w", err)
+	}
+
+	path := u.Query().Get("config")
+	if path == "" {
+		return nil, fmt.Errorf("config not specified (as query param)")
+	}
+
+	return LoadConfig(path)
+}
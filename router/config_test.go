@@ -0,0 +1,53 @@
+package router
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	expected := &Config{
+		Emitters: map[string]string{"billing": "grpc://collector:9010?network=eth-mainnet", "logs": "logger://"},
+		Rules: []Rule{
+			{Endpoint: "sf.substreams.*", Emitters: []string{"billing"}},
+			{Endpoint: "sf.firehose.v2/Fetch", Labels: map[string]string{"tier": "free"}, Metrics: []string{"read_bytes"}, Emitters: []string{"logs"}},
+		},
+		Default: []string{"billing"},
+	}
+
+	yamlPath := filepath.Join(dir, "routes.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+emitters:
+  billing: grpc://collector:9010?network=eth-mainnet
+  logs: logger://
+rules:
+  - endpoint: "sf.substreams.*"
+    emitters: [billing]
+  - endpoint: sf.firehose.v2/Fetch
+    labels: {tier: free}
+    metrics: [read_bytes]
+    emitters: [logs]
+default: [billing]
+`), 0600))
+
+	jsonPath := filepath.Join(dir, "routes.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{
+  "emitters": {"billing": "grpc://collector:9010?network=eth-mainnet", "logs": "logger://"},
+  "rules": [
+    {"endpoint": "sf.substreams.*", "emitters": ["billing"]},
+    {"endpoint": "sf.firehose.v2/Fetch", "labels": {"tier": "free"}, "metrics": ["read_bytes"], "emitters": ["logs"]}
+  ],
+  "default": ["billing"]
+}`), 0600))
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		c, err := newConfig("router://?config=" + path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, c)
+	}
+}
@@ -0,0 +1,219 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/streamingfast/dmetering"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+func Register() {
+	dmetering.Register("router", func(config string, logger *zap.Logger) (dmetering.EventEmitter, error) {
+		c, err := newConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid router config: %w", err)
+		}
+
+		return New(c, logger)
+	})
+}
+
+type emitter struct {
+	emitters map[string]dmetering.EventEmitter
+	rules    []*rule
+	fallback []dmetering.EventEmitter
+}
+
+type rule struct {
+	endpoint *pattern
+	meta     *pattern
+	labels   map[string]*pattern
+	metrics  []*pattern
+	targets  []dmetering.EventEmitter
+}
+
+// New creates the child emitters of config through the dmetering registry and
+// returns an emitter dispatching each event according to config's rules.
+func New(config *Config, logger *zap.Logger) (dmetering.EventEmitter, error) {
+	e := &emitter{
+		emitters: map[string]dmetering.EventEmitter{},
+	}
+
+	names := make([]string, 0, len(config.Emitters))
+	for name := range config.Emitters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child, err := dmetering.New(config.Emitters[name], logger.With(zap.String("emitter", name)))
+		if err != nil {
+			e.Shutdown(err)
+			return nil, fmt.Errorf("unable to create emitter %q: %w", name, err)
+		}
+		e.emitters[name] = child
+	}
+
+	var err error
+	for i, ruleConfig := range config.Rules {
+		r, compileErr := e.compileRule(ruleConfig)
+		if compileErr != nil {
+			err = fmt.Errorf("invalid rule #%d: %w", i, compileErr)
+			break
+		}
+		e.rules = append(e.rules, r)
+	}
+
+	if err == nil {
+		e.fallback, err = e.resolve(config.Default)
+		if err != nil {
+			err = fmt.Errorf("invalid default: %w", err)
+		}
+	}
+
+	if err != nil {
+		e.Shutdown(err)
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (e *emitter) compileRule(config Rule) (r *rule, err error) {
+	r = &rule{labels: map[string]*pattern{}}
+
+	if config.Endpoint != "" {
+		if r.endpoint, err = compilePattern(config.Endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Meta != "" {
+		if r.meta, err = compilePattern(config.Meta); err != nil {
+			return nil, err
+		}
+	}
+
+	for label, source := range config.Labels {
+		if r.labels[label], err = compilePattern(source); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, source := range config.Metrics {
+		p, err := compilePattern(source)
+		if err != nil {
+			return nil, err
+		}
+		r.metrics = append(r.metrics, p)
+	}
+
+	if len(config.Emitters) == 0 {
+		return nil, fmt.Errorf("no emitters specified")
+	}
+
+	if r.targets, err = e.resolve(config.Emitters); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (e *emitter) resolve(names []string) (out []dmetering.EventEmitter, err error) {
+	for _, name := range names {
+		child, found := e.emitters[name]
+		if !found {
+			return nil, fmt.Errorf("unknown emitter %q", name)
+		}
+		out = append(out, child)
+	}
+	return
+}
+
+func (r *rule) match(ev dmetering.Event) bool {
+	if r.endpoint != nil && !r.endpoint.match(ev.Endpoint) {
+		return false
+	}
+
+	if r.meta != nil && !r.meta.match(ev.Meta) {
+		return false
+	}
+
+	for label, p := range r.labels {
+		value, found := ev.Labels[label]
+		if !found || !p.match(value) {
+			return false
+		}
+	}
+
+	if len(r.metrics) == 0 {
+		return true
+	}
+
+	for key := range ev.Metrics {
+		for _, p := range r.metrics {
+			if p.match(key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (e *emitter) Emit(ctx context.Context, ev dmetering.Event) {
+	targets := e.fallback
+	for _, r := range e.rules {
+		if r.match(ev) {
+			targets = r.targets
+			break
+		}
+	}
+
+	for _, target := range targets {
+		target.Emit(ctx, ev)
+	}
+}
+
+// Stats returns the combined stats of all child emitters.
+func (e *emitter) Stats() (stats dmetering.Stats) {
+	for _, child := range e.emitters {
+		childStats, _ := dmetering.EmitterStats(child)
+		stats = stats.Add(childStats)
+	}
+	return stats
+}
+
+// Healthy returns an error listing every unhealthy child emitter, nil when all
+// of them are healthy.
+func (e *emitter) Healthy() (err error) {
+	names := make([]string, 0, len(e.emitters))
+	for name := range e.emitters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if childErr := dmetering.EmitterHealthy(e.emitters[name]); childErr != nil {
+			err = multierr.Append(err, fmt.Errorf("emitter %q: %w", name, childErr))
+		}
+	}
+	return err
+}
+
+// Shutdown shuts every child emitter down concurrently and waits for all of them.
+func (e *emitter) Shutdown(err error) {
+	wg := sync.WaitGroup{}
+	for _, child := range e.emitters {
+		wg.Add(1)
+		go func(child dmetering.EventEmitter) {
+			defer wg.Done()
+			child.Shutdown(err)
+		}(child)
+	}
+	wg.Wait()
+}
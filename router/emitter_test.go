@@ -0,0 +1,113 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var recordersLock sync.Mutex
+var recorders = map[string]*dmeteringtest.Recorder{}
+
+func init() {
+	dmetering.Register("routertest", func(config string, _ *zap.Logger) (dmetering.EventEmitter, error) {
+		u, err := url.Parse(config)
+		if err != nil {
+			return nil, err
+		}
+
+		recordersLock.Lock()
+		defer recordersLock.Unlock()
+
+		recorders[u.Host] = dmeteringtest.NewRecorder()
+		return recorders[u.Host], nil
+	})
+}
+
+func endpoints(recorder *dmeteringtest.Recorder) (out []string) {
+	for _, ev := range recorder.Events() {
+		out = append(out, ev.Endpoint)
+	}
+	return out
+}
+
+func TestEmitter_Routing(t *testing.T) {
+	e, err := New(&Config{
+		Emitters: map[string]string{
+			"billing":   "routertest://billing",
+			"logs":      "routertest://logs",
+			"analytics": "routertest://analytics",
+		},
+		Rules: []Rule{
+			{Endpoint: "sf.substreams.*", Emitters: []string{"billing", "logs"}},
+			{Endpoint: "sf.firehose.v2/Fetch", Emitters: []string{"logs"}},
+			{Endpoint: `regex:^sf\.firehose\.v[12]/`, Labels: map[string]string{"tier": "free*"}, Emitters: []string{"analytics"}},
+			{Metrics: []string{"egress_*"}, Meta: "regex:^debug", Emitters: []string{"logs"}},
+		},
+		Default: []string{"billing"},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	emit := func(ev dmetering.Event) { e.Emit(context.Background(), ev) }
+	emit(dmetering.Event{Endpoint: "sf.substreams.rpc.v2/Blocks"})
+	emit(dmetering.Event{Endpoint: "sf.firehose.v2/Fetch"})
+	emit(dmetering.Event{Endpoint: "sf.firehose.v2/Blocks", Labels: map[string]string{"tier": "free-2024"}})
+	emit(dmetering.Event{Endpoint: "sf.firehose.v1/Blocks", Labels: map[string]string{"tier": "paid"}})
+	emit(dmetering.Event{Endpoint: "debug/egress", Meta: "debug session", Metrics: map[string]float64{"egress_bytes": 1}})
+	emit(dmetering.Event{Endpoint: "other/egress", Meta: "prod", Metrics: map[string]float64{"egress_bytes": 1}})
+
+	assert.Equal(t, []string{"sf.substreams.rpc.v2/Blocks", "sf.firehose.v1/Blocks", "other/egress"}, endpoints(recorders["billing"]))
+	assert.Equal(t, []string{"sf.substreams.rpc.v2/Blocks", "sf.firehose.v2/Fetch", "debug/egress"}, endpoints(recorders["logs"]))
+	assert.Equal(t, []string{"sf.firehose.v2/Blocks"}, endpoints(recorders["analytics"]))
+
+	e.Shutdown(nil)
+	for name, recorder := range recorders {
+		assert.True(t, recorder.IsShutdown(), "emitter %s was not shut down", name)
+	}
+}
+
+func TestNew_invalid(t *testing.T) {
+	_, err := New(&Config{
+		Emitters: map[string]string{"billing": "routertest://billing"},
+		Rules:    []Rule{{Endpoint: "sf.*", Emitters: []string{"unknown"}}},
+	}, zap.NewNop())
+	assert.EqualError(t, err, `invalid rule #0: unknown emitter "unknown"`)
+	assert.True(t, recorders["billing"].IsShutdown(), "children must be shut down when creation fails")
+
+	_, err = New(&Config{
+		Emitters: map[string]string{"billing": "routertest://billing"},
+		Rules:    []Rule{{Endpoint: "regex:(", Emitters: []string{"billing"}}},
+	}, zap.NewNop())
+	assert.ErrorContains(t, err, "invalid regex pattern")
+}
+
+type statsEmitter struct {
+	*dmeteringtest.Recorder
+	stats   dmetering.Stats
+	healthy error
+}
+
+func (e *statsEmitter) Stats() dmetering.Stats { return e.stats }
+func (e *statsEmitter) Healthy() error         { return e.healthy }
+
+func TestEmitter_StatsAndHealth(t *testing.T) {
+	e := &emitter{emitters: map[string]dmetering.EventEmitter{
+		"billing":   &statsEmitter{stats: dmetering.Stats{Emitted: 2, Queued: 1}},
+		"analytics": &statsEmitter{stats: dmetering.Stats{Emitted: 3, Dropped: 1}, healthy: errors.New("buffer is full")},
+		"logs":      dmeteringtest.NewRecorder(),
+	}}
+
+	assert.Equal(t, dmetering.Stats{Emitted: 5, Queued: 1, Dropped: 1}, e.Stats())
+	assert.EqualError(t, e.Healthy(), `emitter "analytics": buffer is full`)
+
+	e.emitters["billing"].(*statsEmitter).healthy = errors.New("collector unavailable")
+	assert.EqualError(t, e.Healthy(), `emitter "analytics": buffer is full; emitter "billing": collector unavailable`)
+}
@@ -0,0 +1,44 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const regexPrefix = "regex:"
+
+type pattern struct {
+	source string
+	regex  *regexp.Regexp
+}
+
+func compilePattern(in string) (*pattern, error) {
+	if strings.HasPrefix(in, regexPrefix) {
+		regex, err := regexp.Compile(strings.TrimPrefix(in, regexPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %q: %w", in, err)
+		}
+		return &pattern{source: in, regex: regex}, nil
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range in {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	return &pattern{source: in, regex: regexp.MustCompile(expr.String())}, nil
+}
+
+func (p *pattern) match(in string) bool {
+	return p.regex.MatchString(in)
+}
@@ -0,0 +1,81 @@
+package sampling
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	// Rate is the fraction of events kept, between 0 and 1.
+	Rate float64
+	// EndpointRates overrides Rate for specific endpoints.
+	EndpointRates map[string]float64
+	// ByUser samples consistently on UserID so that all events of a given user
+	// are either kept or dropped, instead of sampling each event independently.
+	ByUser bool
+	// Emitter is the DSN of the emitter receiving the sampled events.
+	Emitter string
+}
+
+func newConfig(configURL string) (*Config, error) {
+	c := &Config{
+		Rate:          1,
+		EndpointRates: map[string]float64{},
+	}
+
+	u, err := url.Parse(configURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse urls: %w", err)
+	}
+
+	vals := u.Query()
+	c.Emitter = vals.Get("emitter")
+	if c.Emitter == "" {
+		return nil, fmt.Errorf("emitter not specified (as query param)")
+	}
+
+	rateValue := vals.Get("rate")
+	if rateValue != "" {
+		c.Rate, err = parseRate(rateValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate value %q: %w", rateValue, err)
+		}
+	}
+
+	for _, endpointRateValue := range vals["endpointRate"] {
+		idx := strings.LastIndex(endpointRateValue, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid endpointRate value %q: expected <endpoint>:<rate>", endpointRateValue)
+		}
+
+		c.EndpointRates[endpointRateValue[:idx]], err = parseRate(endpointRateValue[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpointRate value %q: %w", endpointRateValue, err)
+		}
+	}
+
+	switch by := vals.Get("by"); by {
+	case "", "event":
+	case "user":
+		c.ByUser = true
+	default:
+		return nil, fmt.Errorf("invalid by value %q: expected \"event\" or \"user\"", by)
+	}
+
+	return c, nil
+}
+
+func parseRate(in string) (float64, error) {
+	rate, err := strconv.ParseFloat(in, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate must be between 0 and 1")
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,53 @@
+package sampling
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_new(t *testing.T) {
+	tests := []struct {
+		dsn         string
+		expect      *Config
+		expectError bool
+	}{
+		{
+			dsn: "sampling://?rate=0.1&emitter=logger://",
+			expect: &Config{
+				Rate:          0.1,
+				EndpointRates: map[string]float64{},
+				Emitter:       "logger://",
+			},
+		},
+		{
+			dsn: "sampling://?rate=0.5&endpointRate=sf.firehose.v2/Fetch:0&endpointRate=sf.substreams.rpc.v2/Blocks:1&by=user&emitter=grpc%3A%2F%2Flocalhost%3A9010%3Fnetwork%3Deth-mainnet",
+			expect: &Config{
+				Rate: 0.5,
+				EndpointRates: map[string]float64{
+					"sf.firehose.v2/Fetch":        0,
+					"sf.substreams.rpc.v2/Blocks": 1,
+				},
+				ByUser:  true,
+				Emitter: "grpc://localhost:9010?network=eth-mainnet",
+			},
+		},
+		{dsn: "sampling://?rate=0.1", expectError: true},
+		{dsn: "sampling://?rate=1.5&emitter=logger://", expectError: true},
+		{dsn: "sampling://?endpointRate=0.5&emitter=logger://", expectError: true},
+		{dsn: "sampling://?by=endpoint&emitter=logger://", expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dsn, func(t *testing.T) {
+			c, err := newConfig(test.dsn)
+			if test.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.expect, c)
+			}
+		})
+	}
+}
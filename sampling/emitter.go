@@ -0,0 +1,119 @@
+package sampling
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+
+	"github.com/streamingfast/dmetering"
+	"go.uber.org/zap"
+)
+
+// RateLabel is the label added to sampled events holding the rate they were sampled at.
+const RateLabel = "sample_rate"
+
+func Register() {
+	dmetering.Register("sampling", func(config string, logger *zap.Logger) (dmetering.EventEmitter, error) {
+		c, err := newConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sampling config: %w", err)
+		}
+
+		next, err := dmetering.New(c.Emitter, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create sampled emitter: %w", err)
+		}
+
+		return New(c, next), nil
+	})
+}
+
+// New returns an emitter forwarding a sample of the events to next. Metrics of
+// kept events are divided by their sampling rate so that totals computed from
+// them remain unbiased estimates of the real totals. Use it for analytics
+// events only, never for billing.
+func New(config *Config, next dmetering.EventEmitter) dmetering.EventEmitter {
+	return &emitter{
+		config: config,
+		next:   next,
+	}
+}
+
+type emitter struct {
+	config *Config
+	next   dmetering.EventEmitter
+}
+
+func (e *emitter) Emit(ctx context.Context, ev dmetering.Event) {
+	rate := e.rate(ev.Endpoint)
+	if rate >= 1 {
+		e.next.Emit(ctx, ev)
+		return
+	}
+
+	if rate <= 0 || e.draw(ev) >= rate {
+		return
+	}
+
+	e.next.Emit(ctx, scale(ev, rate))
+}
+
+func (e *emitter) Shutdown(err error) {
+	e.next.Shutdown(err)
+}
+
+// Stats returns the stats of the wrapped emitter, events discarded by sampling
+// are not counted as dropped.
+func (e *emitter) Stats() dmetering.Stats {
+	stats, _ := dmetering.EmitterStats(e.next)
+	return stats
+}
+
+func (e *emitter) Healthy() error {
+	return dmetering.EmitterHealthy(e.next)
+}
+
+func (e *emitter) rate(endpoint string) float64 {
+	if rate, found := e.config.EndpointRates[endpoint]; found {
+		return rate
+	}
+
+	return e.config.Rate
+}
+
+// draw returns a number in [0, 1), the event is kept if it's below the rate.
+func (e *emitter) draw(ev dmetering.Event) float64 {
+	if !e.config.ByUser {
+		return rand.Float64()
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(ev.UserID))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
+
+// scale divides counter metrics by rate for their totals to be unbiased
+// estimates. Gauges are point-in-time values that sampling doesn't reduce, they
+// are kept as is, metrics that are not registered being counters.
+func scale(ev dmetering.Event, rate float64) dmetering.Event {
+	metrics := make(map[string]float64, len(ev.Metrics))
+	for k, v := range ev.Metrics {
+		if def, found := dmetering.LookupMetric(k); found && def.Kind == dmetering.MetricKindGauge {
+			metrics[k] = v
+			continue
+		}
+		metrics[k] = v / rate
+	}
+	ev.Metrics = metrics
+
+	labels := make(map[string]string, len(ev.Labels)+1)
+	for k, v := range ev.Labels {
+		labels[k] = v
+	}
+	labels[RateLabel] = strconv.FormatFloat(rate, 'g', -1, 64)
+	ev.Labels = labels
+
+	return ev
+}
@@ -0,0 +1,78 @@
+package sampling
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmitter_UnbiasedEstimate(t *testing.T) {
+	next := dmeteringtest.NewRecorder()
+	e := New(&Config{Rate: 0.1}, next)
+
+	for i := 0; i < 100000; i++ {
+		e.Emit(context.Background(), dmetering.Event{Endpoint: "sf.firehose.v2/Blocks", Metrics: map[string]float64{dmetering.MetricReadBytes: 10}})
+	}
+
+	total := 0.0
+	for _, ev := range next.Events() {
+		total += ev.Metrics[dmetering.MetricReadBytes]
+		assert.Equal(t, "0.1", ev.Labels[RateLabel])
+	}
+
+	assert.InDelta(t, 10000, len(next.Events()), 500)
+	assert.InEpsilon(t, 1000000, total, 0.05)
+}
+
+func TestEmitter_ByUser(t *testing.T) {
+	next := dmeteringtest.NewRecorder()
+	e := New(&Config{Rate: 0.5, ByUser: true}, next)
+
+	for round := 0; round < 3; round++ {
+		for user := 0; user < 1000; user++ {
+			e.Emit(context.Background(), dmetering.Event{UserID: fmt.Sprintf("user-%d", user), Metrics: map[string]float64{dmetering.MetricReadBytes: 1}})
+		}
+	}
+
+	perUser := map[string]int{}
+	for _, ev := range next.Events() {
+		perUser[ev.UserID]++
+	}
+
+	for user, count := range perUser {
+		assert.Equal(t, 3, count, "user %s must be fully sampled or not at all", user)
+	}
+	assert.InDelta(t, 500, len(perUser), 75)
+}
+
+func TestEmitter_EndpointRates(t *testing.T) {
+	next := dmeteringtest.NewRecorder()
+	e := New(&Config{Rate: 0, EndpointRates: map[string]float64{"kept": 1}}, next)
+
+	original := dmetering.Event{Endpoint: "kept", Metrics: map[string]float64{dmetering.MetricReadBytes: 1}}
+	e.Emit(context.Background(), original)
+	e.Emit(context.Background(), dmetering.Event{Endpoint: "dropped"})
+
+	assert.Equal(t, []dmetering.Event{original}, next.Events(), "fully sampled events must be forwarded untouched")
+}
+
+func TestEmitter_Gauges(t *testing.T) {
+	const gauge = "sampling_test_active_streams"
+	dmetering.RegisterMetric(dmetering.MetricDefinition{Name: gauge, Unit: dmetering.MetricUnitCount, Kind: dmetering.MetricKindGauge})
+
+	next := dmeteringtest.NewRecorder()
+	e := New(&Config{Rate: 0.5, ByUser: true}, next)
+
+	for user := 0; user < 100; user++ {
+		e.Emit(context.Background(), dmetering.Event{UserID: fmt.Sprintf("user-%d", user), Metrics: map[string]float64{gauge: 3, dmetering.MetricReadBytes: 10}})
+	}
+
+	assert.NotEmpty(t, next.Events())
+	for _, ev := range next.Events() {
+		assert.Equal(t, map[string]float64{gauge: 3, dmetering.MetricReadBytes: 20}, ev.Metrics, "gauges are not scaled")
+	}
+}
@@ -0,0 +1,75 @@
+package dmetering
+
+import (
+	"time"
+)
+
+// Stats is a snapshot of an emitter's activity since it was created.
+type Stats struct {
+	// Queued is the number of events accepted but not sent yet.
+	Queued int
+	// InFlight is the number of batches currently being sent.
+	InFlight int
+
+	// LastSuccess is the time of the last successful send, zero if none.
+	LastSuccess time.Time
+	// LastError is the error of the last failed send and LastErrorAt its time,
+	// they are kept after a later success.
+	LastError   error
+	LastErrorAt time.Time
+
+	// Emitted is the number of events delivered to the destination.
+	Emitted uint64
+	// Dropped is the number of events discarded before being sent (full buffer,
+	// emitter shut down, rejected event ...).
+	Dropped uint64
+	// Failed is the number of events whose send failed.
+	Failed uint64
+}
+
+// Add returns the combination of s and other, counters are summed and the most
+// recent success and error are kept.
+func (s Stats) Add(other Stats) Stats {
+	s.Queued += other.Queued
+	s.InFlight += other.InFlight
+	s.Emitted += other.Emitted
+	s.Dropped += other.Dropped
+	s.Failed += other.Failed
+
+	if other.LastSuccess.After(s.LastSuccess) {
+		s.LastSuccess = other.LastSuccess
+	}
+	if other.LastError != nil && (s.LastError == nil || other.LastErrorAt.After(s.LastErrorAt)) {
+		s.LastError = other.LastError
+		s.LastErrorAt = other.LastErrorAt
+	}
+
+	return s
+}
+
+// StatsProvider is implemented by emitters able to report on their activity,
+// it's optional, use EmitterStats and EmitterHealthy to query any emitter.
+type StatsProvider interface {
+	Stats() Stats
+	// Healthy returns nil when the emitter is able to deliver events, or the
+	// reason why it's not. It's meant to back readiness probes.
+	Healthy() error
+}
+
+// EmitterStats returns the stats of e, ok is false when e does not implement
+// StatsProvider.
+func EmitterStats(e EventEmitter) (stats Stats, ok bool) {
+	if provider, ok := e.(StatsProvider); ok {
+		return provider.Stats(), true
+	}
+	return Stats{}, false
+}
+
+// EmitterHealthy returns the health of e, emitters not implementing
+// StatsProvider are always considered healthy.
+func EmitterHealthy(e EventEmitter) error {
+	if provider, ok := e.(StatsProvider); ok {
+		return provider.Healthy()
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package dmetering_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type statsEmitter struct {
+	*dmeteringtest.Recorder
+	stats   dmetering.Stats
+	healthy error
+}
+
+func (e *statsEmitter) Stats() dmetering.Stats {
+	return e.stats
+}
+
+func (e *statsEmitter) Healthy() error {
+	return e.healthy
+}
+
+func TestStats_Add(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	errA, errB := errors.New("a"), errors.New("b")
+
+	a := dmetering.Stats{Queued: 1, InFlight: 1, Emitted: 10, Dropped: 1, Failed: 2, LastSuccess: t0.Add(time.Minute), LastError: errA, LastErrorAt: t0}
+	b := dmetering.Stats{Queued: 2, Emitted: 5, LastSuccess: t0, LastError: errB, LastErrorAt: t0.Add(time.Second)}
+
+	assert.Equal(t, dmetering.Stats{
+		Queued:      3,
+		InFlight:    1,
+		Emitted:     15,
+		Dropped:     1,
+		Failed:      2,
+		LastSuccess: t0.Add(time.Minute),
+		LastError:   errB,
+		LastErrorAt: t0.Add(time.Second),
+	}, a.Add(b))
+	assert.Equal(t, a, a.Add(dmetering.Stats{}))
+	assert.Equal(t, a, dmetering.Stats{}.Add(a))
+}
+
+func TestEmitterStats_Wrappers(t *testing.T) {
+	_, ok := dmetering.EmitterStats(dmeteringtest.NewRecorder())
+	assert.False(t, ok)
+	assert.NoError(t, dmetering.EmitterHealthy(dmeteringtest.NewRecorder()))
+
+	next := &statsEmitter{Recorder: dmeteringtest.NewRecorder(), stats: dmetering.Stats{Emitted: 3}, healthy: errors.New("down")}
+
+	validating := dmetering.NewValidatingEmitter(next, dmetering.ValidationOptions{}, zap.NewNop())
+	validating.Emit(context.Background(), dmetering.Event{})
+
+	stats, ok := dmetering.EmitterStats(validating)
+	assert.True(t, ok)
+	assert.Equal(t, dmetering.Stats{Emitted: 3, Dropped: 1}, stats)
+	assert.EqualError(t, dmetering.EmitterHealthy(validating), "down")
+
+	defaulting := dmetering.NewDefaultingEmitter(next, nil)
+	stats, _ = dmetering.EmitterStats(defaulting)
+	assert.Equal(t, dmetering.Stats{Emitted: 3}, stats)
+	assert.EqualError(t, dmetering.EmitterHealthy(defaulting), "down")
+}
@@ -0,0 +1,171 @@
+package dmetering
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+type ValidationReason string
+
+const (
+	ValidationReasonMissingEndpoint  ValidationReason = "missing_endpoint"
+	ValidationReasonZeroTimestamp    ValidationReason = "zero_timestamp"
+	ValidationReasonEmptyMetrics     ValidationReason = "empty_metrics"
+	ValidationReasonNegativeMetric   ValidationReason = "negative_metric"
+	ValidationReasonNonFiniteMetric  ValidationReason = "non_finite_metric"
+	ValidationReasonInvalidIPAddress ValidationReason = "invalid_ip_address"
+)
+
+// ValidationOptions relaxes the checks performed by Event.Validate, its zero
+// value applies every rule. NaN and infinite metric values are always invalid.
+type ValidationOptions struct {
+	AllowEmptyEndpoint   bool
+	AllowZeroTimestamp   bool
+	AllowEmptyMetrics    bool
+	AllowNegativeMetrics bool
+	// AllowInvalidIPAddress skips parsing of IpAddress, an empty IpAddress is always valid.
+	AllowInvalidIPAddress bool
+}
+
+type ValidationError struct {
+	Reason  ValidationReason
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors returns the individual *ValidationError combined in err as
+// returned by Event.Validate.
+func ValidationErrors(err error) (out []*ValidationError) {
+	for _, err := range multierr.Errors(err) {
+		if validationErr, ok := err.(*ValidationError); ok {
+			out = append(out, validationErr)
+		}
+	}
+	return
+}
+
+// Validate checks ev against the rules enabled by opts and returns all the
+// violations combined in a single error, nil if ev is valid.
+func (ev Event) Validate(opts ValidationOptions) (err error) {
+	if !opts.AllowEmptyEndpoint && ev.Endpoint == "" {
+		err = multierr.Append(err, &ValidationError{ValidationReasonMissingEndpoint, "endpoint", "must be specified"})
+	}
+
+	if !opts.AllowZeroTimestamp && ev.Timestamp.IsZero() {
+		err = multierr.Append(err, &ValidationError{ValidationReasonZeroTimestamp, "timestamp", "must be specified"})
+	}
+
+	if !opts.AllowEmptyMetrics && len(ev.Metrics) == 0 {
+		err = multierr.Append(err, &ValidationError{ValidationReasonEmptyMetrics, "metrics", "must contain at least one metric"})
+	}
+
+	keys := make([]string, 0, len(ev.Metrics))
+	for k := range ev.Metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value := ev.Metrics[k]
+		switch {
+		case math.IsNaN(value) || math.IsInf(value, 0):
+			err = multierr.Append(err, &ValidationError{ValidationReasonNonFiniteMetric, "metrics." + k, fmt.Sprintf("must be finite, got %v", value)})
+		case !opts.AllowNegativeMetrics && value < 0:
+			err = multierr.Append(err, &ValidationError{ValidationReasonNegativeMetric, "metrics." + k, fmt.Sprintf("must not be negative, got %v", value)})
+		}
+	}
+
+	if !opts.AllowInvalidIPAddress && ev.IpAddress != "" && net.ParseIP(ev.IpAddress) == nil {
+		err = multierr.Append(err, &ValidationError{ValidationReasonInvalidIPAddress, "ip_address", fmt.Sprintf("%q is not a valid IP address", ev.IpAddress)})
+	}
+
+	return err
+}
+
+// ValidatingEmitter drops events that do not pass Event.Validate before they
+// reach the wrapped emitter, keeping count of the reasons they were rejected.
+type ValidatingEmitter struct {
+	next   EventEmitter
+	opts   ValidationOptions
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	rejected uint64
+	reasons  map[ValidationReason]uint64
+}
+
+func NewValidatingEmitter(next EventEmitter, opts ValidationOptions, logger *zap.Logger) *ValidatingEmitter {
+	return &ValidatingEmitter{
+		next:    next,
+		opts:    opts,
+		logger:  logger,
+		reasons: map[ValidationReason]uint64{},
+	}
+}
+
+func (e *ValidatingEmitter) Emit(ctx context.Context, ev Event) {
+	if err := ev.Validate(e.opts); err != nil {
+		e.record(err)
+		e.logger.Warn("dropping invalid event", zap.Object("event", ev), zap.Error(err))
+		return
+	}
+
+	e.next.Emit(ctx, ev)
+}
+
+func (e *ValidatingEmitter) Shutdown(err error) {
+	e.next.Shutdown(err)
+}
+
+// Stats returns the stats of the wrapped emitter, rejected events counting as dropped.
+func (e *ValidatingEmitter) Stats() Stats {
+	stats, _ := EmitterStats(e.next)
+	stats.Dropped += e.RejectedCount()
+	return stats
+}
+
+func (e *ValidatingEmitter) Healthy() error {
+	return EmitterHealthy(e.next)
+}
+
+func (e *ValidatingEmitter) record(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rejected++
+	for _, validationErr := range ValidationErrors(err) {
+		e.reasons[validationErr.Reason]++
+	}
+}
+
+// RejectedCount returns the number of events dropped so far.
+func (e *ValidatingEmitter) RejectedCount() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.rejected
+}
+
+// RejectionReasons returns how many times each rule was violated, an event
+// violating multiple rules is counted once per rule.
+func (e *ValidatingEmitter) RejectionReasons() map[ValidationReason]uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := make(map[ValidationReason]uint64, len(e.reasons))
+	for reason, count := range e.reasons {
+		out[reason] = count
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package dmetering_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/streamingfast/dmetering"
+	"github.com/streamingfast/dmetering/dmeteringtest"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestEvent_Validate(t *testing.T) {
+	valid := dmetering.Event{
+		Endpoint:  "sf.firehose.v2/Blocks",
+		Metrics:   map[string]float64{dmetering.MetricReadBytes: 10},
+		IpAddress: "2001:db8::1",
+		Timestamp: time.Unix(1700000000, 0),
+	}
+
+	tests := []struct {
+		name          string
+		event         func(ev dmetering.Event) dmetering.Event
+		opts          dmetering.ValidationOptions
+		expectReasons []dmetering.ValidationReason
+	}{
+		{"valid", func(ev dmetering.Event) dmetering.Event { return ev }, dmetering.ValidationOptions{}, nil},
+		{
+			"everything wrong",
+			func(ev dmetering.Event) dmetering.Event {
+				return dmetering.Event{IpAddress: "256.1.1.1"}
+			},
+			dmetering.ValidationOptions{},
+			[]dmetering.ValidationReason{dmetering.ValidationReasonMissingEndpoint, dmetering.ValidationReasonZeroTimestamp, dmetering.ValidationReasonEmptyMetrics, dmetering.ValidationReasonInvalidIPAddress},
+		},
+		{
+			"everything wrong but allowed",
+			func(ev dmetering.Event) dmetering.Event {
+				return dmetering.Event{IpAddress: "256.1.1.1"}
+			},
+			dmetering.ValidationOptions{AllowEmptyEndpoint: true, AllowZeroTimestamp: true, AllowEmptyMetrics: true, AllowInvalidIPAddress: true},
+			nil,
+		},
+		{
+			"bad metric values",
+			func(ev dmetering.Event) dmetering.Event {
+				ev.Metrics = map[string]float64{"a": math.NaN(), "b": math.Inf(1), "c": -1, "d": 0}
+				return ev
+			},
+			dmetering.ValidationOptions{},
+			[]dmetering.ValidationReason{dmetering.ValidationReasonNonFiniteMetric, dmetering.ValidationReasonNonFiniteMetric, dmetering.ValidationReasonNegativeMetric},
+		},
+		{
+			"negative allowed but never non-finite",
+			func(ev dmetering.Event) dmetering.Event {
+				ev.Metrics = map[string]float64{"a": math.Inf(-1), "c": -1}
+				return ev
+			},
+			dmetering.ValidationOptions{AllowNegativeMetrics: true},
+			[]dmetering.ValidationReason{dmetering.ValidationReasonNonFiniteMetric},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.event(valid).Validate(test.opts)
+			if test.expectReasons == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var reasons []dmetering.ValidationReason
+			for _, validationErr := range dmetering.ValidationErrors(err) {
+				reasons = append(reasons, validationErr.Reason)
+			}
+			assert.Equal(t, test.expectReasons, reasons)
+		})
+	}
+}
+
+func TestValidatingEmitter(t *testing.T) {
+	next := dmeteringtest.NewRecorder()
+	emitter := dmetering.NewValidatingEmitter(next, dmetering.ValidationOptions{AllowZeroTimestamp: true}, zap.NewNop())
+
+	emitter.Emit(context.Background(), dmetering.Event{Endpoint: "sf.firehose.v2/Blocks", Metrics: map[string]float64{dmetering.MetricReadBytes: 1}})
+	emitter.Emit(context.Background(), dmetering.Event{Metrics: map[string]float64{dmetering.MetricReadBytes: -1}})
+	emitter.Emit(context.Background(), dmetering.Event{Endpoint: "sf.firehose.v2/Blocks"})
+
+	assert.Len(t, next.Events(), 1)
+	assert.Equal(t, uint64(2), emitter.RejectedCount())
+	assert.Equal(t, map[dmetering.ValidationReason]uint64{
+		dmetering.ValidationReasonMissingEndpoint: 1,
+		dmetering.ValidationReasonNegativeMetric:  1,
+		dmetering.ValidationReasonEmptyMetrics:    1,
+	}, emitter.RejectionReasons())
+}